@@ -27,7 +27,9 @@ func main() {
 	r := reverser.NewReverser(*conf)
 	done := setupGracefulShutdown(r)
 
-	log.Printf("Starting reverser on %s", conf.Listen)
+	for _, l := range conf.Listeners {
+		log.Printf("Starting reverser on %s (%s)", l.Address, l.Protocol)
+	}
 	err = r.Start()
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start reverser: %v", err)
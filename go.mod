@@ -2,4 +2,8 @@ module github.com/papey/cmiyc
 
 go 1.25.1
 
-require gopkg.in/yaml.v2 v2.4.0
+require (
+	github.com/andybalholm/brotli v1.2.6
+	github.com/klauspost/compress v1.18.0
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -1,5 +1,60 @@
 package balancer
 
+import "sync/atomic"
+
 type Balancer interface {
 	Pick() string
+	// PickLeastConnected picks the backend holding the fewest acquired
+	// connections, for the long lived ones such as upgraded connections.
+	PickLeastConnected() string
+	Acquire(url string)
+	Release(url string)
+	ActiveConnections(url string) int64
+}
+
+type connections struct {
+	urls   []string
+	counts map[string]*atomic.Int64
+}
+
+func newConnections(urls []string) connections {
+	counts := make(map[string]*atomic.Int64, len(urls))
+	for _, u := range urls {
+		counts[u] = &atomic.Int64{}
+	}
+
+	return connections{urls: urls, counts: counts}
+}
+
+// leastConnected returns the usable backend holding the fewest connections,
+// preferred on ties so that the strategy of the balancer spreads them.
+func (c *connections) leastConnected(preferred string, usable func(url string) bool) string {
+	best := preferred
+	for _, u := range c.urls {
+		if (usable == nil || usable(u)) && c.ActiveConnections(u) < c.ActiveConnections(best) {
+			best = u
+		}
+	}
+
+	return best
+}
+
+func (c *connections) Acquire(url string) {
+	if count, exists := c.counts[url]; exists {
+		count.Add(1)
+	}
+}
+
+func (c *connections) Release(url string) {
+	if count, exists := c.counts[url]; exists {
+		count.Add(-1)
+	}
+}
+
+func (c *connections) ActiveConnections(url string) int64 {
+	if count, exists := c.counts[url]; exists {
+		return count.Load()
+	}
+
+	return 0
 }
@@ -0,0 +1,50 @@
+package balancer
+
+import "testing"
+
+func TestConnectionsAccounting(t *testing.T) {
+	lb := NewRRBalancer([]string{"http://backend1", "http://backend2"})
+
+	lb.Acquire("http://backend1")
+	lb.Acquire("http://backend1")
+	lb.Acquire("http://backend2")
+	lb.Release("http://backend1")
+	lb.Acquire("http://unknown")
+
+	if got := lb.ActiveConnections("http://backend1"); got != 1 {
+		t.Errorf("expected 1 connection on backend1, got %d", got)
+	}
+	if got := lb.ActiveConnections("http://backend2"); got != 1 {
+		t.Errorf("expected 1 connection on backend2, got %d", got)
+	}
+	if got := lb.ActiveConnections("http://unknown"); got != 0 {
+		t.Errorf("expected unknown backends to be ignored, got %d", got)
+	}
+}
+
+func TestPickLeastConnected(t *testing.T) {
+	urls := []string{"http://backend1", "http://backend2", "http://backend3"}
+	lb := NewRRBalancer(urls)
+
+	for i := 0; i < 6; i++ {
+		lb.Acquire(lb.PickLeastConnected())
+	}
+	for _, u := range urls {
+		if got := lb.ActiveConnections(u); got != 2 {
+			t.Errorf("expected connections to be spread evenly, %s holds %d", u, got)
+		}
+	}
+
+	lb.Release("http://backend2")
+	for i := 0; i < 3; i++ {
+		if got := lb.PickLeastConnected(); got != "http://backend2" {
+			t.Errorf("expected the least connected backend, got %s", got)
+		}
+	}
+
+	single := NewSingleLB(urls)
+	single.Acquire("http://backend1")
+	if got := single.PickLeastConnected(); got != "http://backend1" {
+		t.Errorf("expected the single balancer to stick to its backend, got %s", got)
+	}
+}
@@ -0,0 +1,47 @@
+package balancer
+
+type HealthAwareLB struct {
+	Balancer
+	urls    []string
+	healthy func(url string) bool
+}
+
+func NewHealthAwareLB(lb Balancer, urls []string, healthy func(url string) bool) *HealthAwareLB {
+	return &HealthAwareLB{
+		Balancer: lb,
+		urls:     urls,
+		healthy:  healthy,
+	}
+}
+
+func (h *HealthAwareLB) Pick() string {
+	for range h.urls {
+		if picked := h.Balancer.Pick(); h.healthy(picked) {
+			return picked
+		}
+	}
+
+	for _, u := range h.urls {
+		if h.healthy(u) {
+			return u
+		}
+	}
+
+	return h.Balancer.Pick()
+}
+
+func (h *HealthAwareLB) PickLeastConnected() string {
+	picked := h.Balancer.PickLeastConnected()
+	if h.healthy(picked) {
+		return picked
+	}
+
+	best := h.Pick()
+	for _, u := range h.urls {
+		if h.healthy(u) && h.ActiveConnections(u) < h.ActiveConnections(best) {
+			best = u
+		}
+	}
+
+	return best
+}
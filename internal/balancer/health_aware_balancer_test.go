@@ -0,0 +1,42 @@
+package balancer
+
+import "testing"
+
+func TestHealthAwareLBSkipsUnhealthyBackends(t *testing.T) {
+	urls := []string{"http://backend1", "http://backend2", "http://backend3"}
+	unhealthy := map[string]bool{"http://backend1": true}
+
+	lb := NewHealthAwareLB(NewSingleLB(urls), urls, func(url string) bool { return !unhealthy[url] })
+
+	if got := lb.Pick(); got != "http://backend2" {
+		t.Errorf("expected first healthy backend, got %s", got)
+	}
+
+	rr := NewHealthAwareLB(NewRRBalancer(urls), urls, func(url string) bool { return !unhealthy[url] })
+	for i := 0; i < 6; i++ {
+		if got := rr.Pick(); got == "http://backend1" {
+			t.Fatalf("pick #%d returned unhealthy backend", i+1)
+		}
+	}
+
+	unhealthy["http://backend2"] = true
+	unhealthy["http://backend3"] = true
+	if got := lb.Pick(); got != "http://backend1" {
+		t.Errorf("expected fallback to the wrapped balancer when all backends are down, got %s", got)
+	}
+}
+
+func TestHealthAwareLBPicksTheLeastConnectedHealthyBackend(t *testing.T) {
+	urls := []string{"http://backend1", "http://backend2", "http://backend3"}
+	unhealthy := map[string]bool{"http://backend1": true}
+
+	rr := NewRRBalancer(urls)
+	lb := NewHealthAwareLB(rr, urls, func(url string) bool { return !unhealthy[url] })
+
+	rr.Acquire("http://backend2")
+	for i := 0; i < 3; i++ {
+		if got := lb.PickLeastConnected(); got != "http://backend3" {
+			t.Errorf("expected the least connected healthy backend, got %s", got)
+		}
+	}
+}
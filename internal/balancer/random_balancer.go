@@ -5,14 +5,16 @@ import (
 )
 
 type RandomLB struct {
+	connections
 	urls []string
 	rnd  *rand.Rand
 }
 
 func NewRandomLB(urls []string, seed int64) *RandomLB {
 	return &RandomLB{
-		urls: urls,
-		rnd:  rand.New(rand.NewSource(seed)),
+		connections: newConnections(urls),
+		urls:        urls,
+		rnd:         rand.New(rand.NewSource(seed)),
 	}
 }
 
@@ -23,3 +25,7 @@ func (r *RandomLB) Pick() string {
 	idx := r.rnd.Intn(len(r.urls))
 	return r.urls[idx]
 }
+
+func (r *RandomLB) PickLeastConnected() string {
+	return r.leastConnected(r.Pick(), nil)
+}
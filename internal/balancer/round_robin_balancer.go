@@ -6,12 +6,13 @@ import (
 )
 
 type RRBalancer struct {
+	connections
 	urls  []string
 	index atomic.Int64
 }
 
 func NewRRBalancer(urls []string) *RRBalancer {
-	return &RRBalancer{urls: urls}
+	return &RRBalancer{connections: newConnections(urls), urls: urls}
 }
 
 func (r *RRBalancer) Pick() string {
@@ -22,3 +23,7 @@ func (r *RRBalancer) Pick() string {
 
 	return r.urls[i%n]
 }
+
+func (r *RRBalancer) PickLeastConnected() string {
+	return r.leastConnected(r.Pick(), nil)
+}
@@ -1,13 +1,20 @@
 package balancer
 
 type SingleLB struct {
+	connections
 	urls []string
 }
 
 func NewSingleLB(urls []string) *SingleLB {
-	return &SingleLB{urls: urls}
+	return &SingleLB{connections: newConnections(urls), urls: urls}
 }
 
 func (s *SingleLB) Pick() string {
 	return s.urls[0]
 }
+
+// PickLeastConnected always picks the first backend, the others are only
+// listed.
+func (s *SingleLB) PickLeastConnected() string {
+	return s.Pick()
+}
@@ -0,0 +1,102 @@
+package cache
+
+// arcPolicy implements Adaptive Replacement Cache (Megiddo & Modha) with byte
+// sizes: t1 holds keys seen once recently, t2 keys seen at least twice, b1 and
+// b2 are ghost lists remembering keys recently evicted from t1 and t2. The
+// target size of t1 adapts depending on which ghost list gets hits.
+type arcPolicy struct {
+	t1, t2 *lruList
+	b1, b2 *lruList
+	target int // in bytes
+}
+
+func NewARCPolicy() EvictionPolicy {
+	return &arcPolicy{
+		t1: newLRUList(),
+		t2: newLRUList(),
+		b1: newLRUList(),
+		b2: newLRUList(),
+	}
+}
+
+func (p *arcPolicy) capacity() int {
+	return p.t1.bytes + p.t2.bytes
+}
+
+func (p *arcPolicy) Add(key Key, size int) {
+	switch {
+	case p.t1.contains(key) || p.t2.contains(key):
+		p.t1.remove(key)
+		p.t2.add(key, size)
+	case p.b1.contains(key):
+		delta := size
+		if p.b1.bytes > 0 && p.b2.bytes > p.b1.bytes {
+			delta = size * p.b2.bytes / p.b1.bytes
+		}
+		p.target = min(p.target+delta, p.capacity()+size)
+		p.b1.remove(key)
+		p.t2.add(key, size)
+	case p.b2.contains(key):
+		delta := size
+		if p.b2.bytes > 0 && p.b1.bytes > p.b2.bytes {
+			delta = size * p.b1.bytes / p.b2.bytes
+		}
+		p.target = max(p.target-delta, 0)
+		p.b2.remove(key)
+		p.t2.add(key, size)
+	default:
+		p.t1.add(key, size)
+	}
+
+	p.trimGhosts()
+}
+
+func (p *arcPolicy) Touch(key Key) {
+	if size, found := p.t1.remove(key); found {
+		p.t2.add(key, size)
+		return
+	}
+
+	p.t2.touch(key)
+}
+
+func (p *arcPolicy) Remove(key Key) {
+	p.t1.remove(key)
+	p.t2.remove(key)
+	p.b1.remove(key)
+	p.b2.remove(key)
+}
+
+func (p *arcPolicy) Evict(incoming Key, _ int) (Key, bool) {
+	fromT1 := p.t1.len() > 0 && (p.t1.bytes > p.target || (p.b2.contains(incoming) && p.t1.bytes == p.target) || p.t2.len() == 0)
+
+	if fromT1 {
+		key, size, _ := p.t1.popOldest()
+		p.b1.add(key, size)
+		return key, true
+	}
+
+	key, size, found := p.t2.popOldest()
+	if !found {
+		return "", false
+	}
+	p.b2.add(key, size)
+
+	return key, true
+}
+
+func (p *arcPolicy) evictionOrder() []Key {
+	return append(p.t1.keys(), p.t2.keys()...)
+}
+
+// trimGhosts keeps ghost lists from remembering more bytes than the cache holds.
+func (p *arcPolicy) trimGhosts() {
+	limit := p.capacity()
+	for p.b1.bytes+p.b2.bytes > limit && (p.b1.len() > 0 || p.b2.len() > 0) {
+		if p.b1.bytes > p.b2.bytes {
+			p.b1.popOldest()
+		} else {
+			p.b2.popOldest()
+		}
+	}
+}
@@ -3,13 +3,16 @@ package cache
 import (
 	"bytes"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type CachableResponse struct {
 	http.ResponseWriter
-	StatusCode int
-	Body       *bytes.Buffer
+	StatusCode  int
+	Body        *bytes.Buffer
+	MaxBodySize int // in bytes, 0 means unlimited
+	uncaptured  bool
 }
 
 func NewCachableResponse(w http.ResponseWriter) *CachableResponse {
@@ -21,20 +24,52 @@ func NewCachableResponse(w http.ResponseWriter) *CachableResponse {
 }
 
 func (cr *CachableResponse) Write(data []byte) (int, error) {
-	cr.Body.Write(data)
+	if !cr.uncaptured {
+		if cr.MaxBodySize > 0 && cr.Body.Len()+len(data) > cr.MaxBodySize {
+			cr.StopCapture()
+		} else {
+			cr.Body.Write(data)
+		}
+	}
+
 	return cr.ResponseWriter.Write(data)
 }
 
 func (cr *CachableResponse) WriteHeader(statusCode int) {
 	cr.StatusCode = statusCode
+	if isEventStream(cr.Header()) {
+		cr.StopCapture()
+	}
 	cr.ResponseWriter.WriteHeader(statusCode)
 }
 
+func (cr *CachableResponse) Flush() {
+	_ = http.NewResponseController(cr.ResponseWriter).Flush()
+}
+
+func (cr *CachableResponse) Unwrap() http.ResponseWriter {
+	return cr.ResponseWriter
+}
+
+// StopCapture drops the buffered body and stops buffering further writes, the
+// response is then no longer considered cachable.
+func (cr *CachableResponse) StopCapture() {
+	cr.uncaptured = true
+	cr.Body = &bytes.Buffer{}
+}
+
+func (cr *CachableResponse) IsCaptured() bool {
+	return !cr.uncaptured
+}
+
+func isEventStream(h http.Header) bool {
+	return strings.HasPrefix(strings.ToLower(h.Get("Content-Type")), "text/event-stream")
+}
+
 var cachableResponseStatuses = []int{
 	http.StatusOK,                   // 200
 	http.StatusNonAuthoritativeInfo, // 203
 	http.StatusNoContent,            // 204
-	http.StatusPartialContent,       // 206
 	http.StatusMultipleChoices,      // 300
 	http.StatusMovedPermanently,     // 301
 	http.StatusNotFound,             // 404
@@ -55,27 +90,40 @@ func isCachableStatus(status int) bool {
 }
 
 func (cr *CachableResponse) CacheTTL() (bool, time.Duration) {
-	present, duration := ParseCacheControl(cr.Header().Get("Cache-Control")).TTL()
-	if present {
-		return true, duration
-	}
-
-	expiresHeader := cr.Header().Get("Expires")
-	if expiresHeader != "" {
-		if expiresTime, err := http.ParseTime(expiresHeader); err == nil {
-			return true, time.Until(expiresTime)
-		}
-	}
+	return HeaderTTL(cr.Header())
+}
 
-	return false, 0
+func (cr *CachableResponse) varyAll() bool {
+	_, all := parseVary(cr.Header())
+	return all
 }
 
 func (cr *CachableResponse) IsCachable() bool {
-	return isCachableStatus(cr.StatusCode) && ParseCacheControl(cr.Header().Get("Cache-Control")).isCachable()
+	cc := ParseCacheControl(cr.Header().Get("Cache-Control"))
+	return cr.IsCaptured() && !cr.varyAll() && cr.isStorableStatus(cc) && cc.isCachable()
 }
 
+// IsCachableConsideringAuth tells if the response may be stored although the
+// request carried an Authorization header.
 func (cr *CachableResponse) IsCachableConsideringAuth() bool {
-	return isCachableStatus(cr.StatusCode) && ParseCacheControl(cr.Header().Get("Cache-Control")).isCachable()
+	return cr.IsCachable() && ParseCacheControl(cr.Header().Get("Cache-Control")).allowsAuthorized()
+}
+
+// isStorableStatus accepts the heuristically cachable statuses, and any other
+// final status the response explicitly marks as cachable (RFC 9111 §3).
+func (cr *CachableResponse) isStorableStatus(cc *HeaderCacheControl) bool {
+	if isCachableStatus(cr.StatusCode) {
+		return true
+	}
+
+	switch {
+	case cr.StatusCode < http.StatusOK, cr.StatusCode == http.StatusNotModified, cr.StatusCode == http.StatusPartialContent:
+		return false
+	case http.StatusText(cr.StatusCode) == "":
+		return false
+	}
+
+	return cc.Public || cc.MaxAge >= 0 || cc.SMaxAge >= 0 || cr.Header().Get("Expires") != ""
 }
 
 func IsRequestCachable(requestMethod string) bool {
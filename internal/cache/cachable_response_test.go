@@ -32,9 +32,9 @@ func TestWriteAndWriteHeader(t *testing.T) {
 func TestIsCachableStatus(t *testing.T) {
 	validStatuses := []int{
 		http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
-		http.StatusPartialContent, http.StatusMultipleChoices, http.StatusMovedPermanently,
-		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
-		http.StatusRequestURITooLong, http.StatusNotImplemented,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusNotFound,
+		http.StatusMethodNotAllowed, http.StatusGone, http.StatusRequestURITooLong,
+		http.StatusNotImplemented,
 	}
 
 	for _, code := range validStatuses {
@@ -43,7 +43,8 @@ func TestIsCachableStatus(t *testing.T) {
 		}
 	}
 
-	invalidStatuses := []int{201, 202, 500, 302, 403}
+	// Partial responses are served from cached full responses instead.
+	invalidStatuses := []int{201, 202, 206, 500, 302, 403}
 	for _, code := range invalidStatuses {
 		if isCachableStatus(code) {
 			t.Errorf("expected %d to NOT be cachable", code)
@@ -93,11 +94,16 @@ func TestIsCachableConsideringAuth(t *testing.T) {
 	rec := httptest.NewRecorder()
 	cr := NewCachableResponse(rec)
 	cr.StatusCode = http.StatusOK
-	cr.Header().Set("Cache-Control", "max-age=120")
+	cr.Header().Set("Cache-Control", "public, max-age=120")
 
 	if !cr.IsCachableConsideringAuth() {
 		t.Errorf("expected IsCachableConsideringAuth() to return true for cachable header")
 	}
+
+	cr.Header().Set("Cache-Control", "max-age=120")
+	if cr.IsCachableConsideringAuth() {
+		t.Errorf("expected IsCachableConsideringAuth() to return false without public, must-revalidate or s-maxage")
+	}
 }
 
 func TestCachableResponseHeadRequest(t *testing.T) {
@@ -129,3 +135,44 @@ func TestCachableResponseHeadRequest(t *testing.T) {
 		t.Errorf("expected header X-Test=value, got %s", cr.Header().Get("X-Test"))
 	}
 }
+
+func TestCachableResponseStopsCapturingOverMaxBodySize(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cr := NewCachableResponse(rec)
+	cr.MaxBodySize = 8
+	cr.Header().Set("Cache-Control", "max-age=60")
+
+	_, _ = cr.Write([]byte("hello"))
+	_, _ = cr.Write([]byte(" world"))
+	_, _ = cr.Write([]byte("!"))
+
+	if cr.IsCaptured() {
+		t.Error("expected capture to stop once MaxBodySize is exceeded")
+	}
+	if cr.Body.Len() != 0 {
+		t.Errorf("expected captured body to be dropped, got %d bytes", cr.Body.Len())
+	}
+	if rec.Body.String() != "hello world!" {
+		t.Errorf("expected full body to reach the client, got %q", rec.Body.String())
+	}
+	if cr.IsCachable() {
+		t.Error("expected truncated response to not be cachable")
+	}
+}
+
+func TestCachableResponseEventStreamIsNotCaptured(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cr := NewCachableResponse(rec)
+	cr.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	cr.WriteHeader(http.StatusOK)
+
+	_, _ = cr.Write([]byte("data: hello\n\n"))
+	cr.Flush()
+
+	if cr.IsCaptured() || cr.Body.Len() != 0 {
+		t.Error("expected event streams to bypass body capture")
+	}
+	if !rec.Flushed {
+		t.Error("expected Flush to reach the underlying writer")
+	}
+}
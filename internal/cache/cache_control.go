@@ -1,51 +1,103 @@
 package cache
 
 import (
-	"fmt"
+	"errors"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// AnyStale is the MaxStale value of a max-stale directive given without value.
+const AnyStale int64 = -2
+
+// maxDeltaSeconds is the greatest delta-seconds value recipients have to
+// handle, larger values are clamped to it (RFC 9111 §1.2.2).
+const maxDeltaSeconds int64 = 1 << 31
+
 type HeaderCacheControl struct {
-	NoStore bool
-	Private bool
-	NoCache bool
-	MaxAge  int64
-	SMaxAge int64
+	NoStore              bool
+	Private              bool
+	PrivateFields        []string // private="...", only the listed fields are kept out of shared caches
+	NoCache              bool
+	NoCacheFields        []string // no-cache="...", only the listed fields need a revalidation
+	Public               bool
+	MustRevalidate       bool
+	ProxyRevalidate      bool
+	NoTransform          bool
+	Immutable            bool
+	MaxAge               int64
+	SMaxAge              int64
+	StaleWhileRevalidate int64
+	StaleIfError         int64
+
+	// Request only directives.
+	MaxStale     int64
+	MinFresh     int64
+	OnlyIfCached bool
 }
 
 func ParseCacheControl(cacheControlValue string) *HeaderCacheControl {
 	cc := &HeaderCacheControl{
-		MaxAge:  -1,
-		SMaxAge: -1,
+		MaxAge:               -1,
+		SMaxAge:              -1,
+		StaleWhileRevalidate: -1,
+		StaleIfError:         -1,
+		MaxStale:             -1,
+		MinFresh:             -1,
 	}
 
 	if cacheControlValue == "" {
 		return cc
 	}
 
-	directives := strings.Split(cacheControlValue, ",")
-	for _, raw := range directives {
-		directive := strings.ToLower(strings.TrimSpace(raw))
+	for _, raw := range splitQuotedList(cacheControlValue) {
+		name, arg, hasArg := strings.Cut(raw, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		arg = unquote(strings.TrimSpace(arg))
 
-		switch {
-		case directive == "no-store":
+		switch name {
+		case "no-store":
 			cc.NoStore = true
-		case directive == "private":
-			cc.Private = true
-		case directive == "no-cache":
-			cc.NoCache = true
-		case strings.HasPrefix(directive, "max-age="):
-			var n int64
-			_, err := fmt.Sscanf(directive, "max-age=%d", &n)
-			if err == nil {
-				cc.MaxAge = n
+		case "private":
+			if hasArg && arg != "" {
+				cc.PrivateFields = append(cc.PrivateFields, fieldNames(arg)...)
+			} else {
+				cc.Private = true
 			}
-		case strings.HasPrefix(directive, "s-maxage="):
-			var n int64
-			_, err := fmt.Sscanf(directive, "s-maxage=%d", &n)
-			if err == nil {
-				cc.SMaxAge = n
+		case "no-cache":
+			if hasArg && arg != "" {
+				cc.NoCacheFields = append(cc.NoCacheFields, fieldNames(arg)...)
+			} else {
+				cc.NoCache = true
+			}
+		case "public":
+			cc.Public = true
+		case "must-revalidate":
+			cc.MustRevalidate = true
+		case "proxy-revalidate":
+			cc.ProxyRevalidate = true
+		case "no-transform":
+			cc.NoTransform = true
+		case "immutable":
+			cc.Immutable = true
+		case "only-if-cached":
+			cc.OnlyIfCached = true
+		case "max-age":
+			cc.MaxAge = parseDeltaSeconds(arg, cc.MaxAge)
+		case "s-maxage":
+			cc.SMaxAge = parseDeltaSeconds(arg, cc.SMaxAge)
+		case "stale-while-revalidate":
+			cc.StaleWhileRevalidate = parseDeltaSeconds(arg, cc.StaleWhileRevalidate)
+		case "stale-if-error":
+			cc.StaleIfError = parseDeltaSeconds(arg, cc.StaleIfError)
+		case "min-fresh":
+			cc.MinFresh = parseDeltaSeconds(arg, cc.MinFresh)
+		case "max-stale":
+			if hasArg {
+				cc.MaxStale = parseDeltaSeconds(arg, cc.MaxStale)
+			} else {
+				cc.MaxStale = AnyStale
 			}
 		}
 	}
@@ -53,15 +105,116 @@ func ParseCacheControl(cacheControlValue string) *HeaderCacheControl {
 	return cc
 }
 
+// ParseRequestCacheControl reads the directives of a request, a Pragma:
+// no-cache is honored when no Cache-Control is given (RFC 9111 §5.4).
+func ParseRequestCacheControl(r *http.Request) *HeaderCacheControl {
+	value := r.Header.Get("Cache-Control")
+	if value == "" && headerHasToken(r.Header, "Pragma", "no-cache") {
+		value = "no-cache"
+	}
+
+	return ParseCacheControl(value)
+}
+
+func parseDeltaSeconds(value string, fallback int64) int64 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(value, "-") {
+		return maxDeltaSeconds
+	}
+	if err != nil || n < 0 {
+		return fallback
+	}
+
+	return min(n, maxDeltaSeconds)
+}
+
+func fieldNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	return names
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+// splitQuotedList splits a comma separated header value, commas may appear
+// inside quoted strings.
+func splitQuotedList(list string) []string {
+	var items []string
+	var current strings.Builder
+	quoted := false
+
+	for _, c := range list {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			current.WriteRune(c)
+		case c == ',' && !quoted:
+			if item := strings.TrimSpace(current.String()); item != "" {
+				items = append(items, item)
+			}
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+
+	if item := strings.TrimSpace(current.String()); item != "" {
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (cc *HeaderCacheControl) isCachable() bool {
-	return !cc.NoStore && !cc.Private && (cc.MaxAge != 0 || cc.SMaxAge != 0)
+	return !cc.NoStore && !cc.Private && (cc.NoCache || cc.MaxAge != 0 || cc.SMaxAge != 0)
 }
 
 func (cc *HeaderCacheControl) isExplicitlyCachable() bool {
 	return cc.isCachable() && (cc.MaxAge > 0 || cc.SMaxAge > 0)
 }
 
+// allowsAuthorized tells if a response to a request carrying an Authorization
+// header may be stored by a shared cache (RFC 9111 §3.5).
+func (cc *HeaderCacheControl) allowsAuthorized() bool {
+	return cc.Public || cc.MustRevalidate || cc.SMaxAge >= 0
+}
+
+// mustRevalidateWhenStale tells if a stale response must never be served
+// without a successful revalidation.
+func (cc *HeaderCacheControl) mustRevalidateWhenStale() bool {
+	return cc.MustRevalidate || cc.ProxyRevalidate || cc.NoCache
+}
+
 func (cc *HeaderCacheControl) TTL() (bool, time.Duration) {
+	// no-cache responses may be stored but are stale right away, every use
+	// goes through a revalidation.
+	if cc.NoCache {
+		return true, 0
+	}
+
 	if cc.SMaxAge >= 0 {
 		return true, time.Duration(cc.SMaxAge) * time.Second
 	}
@@ -72,3 +225,24 @@ func (cc *HeaderCacheControl) TTL() (bool, time.Duration) {
 
 	return false, 0
 }
+
+// StalePolicy overrides the given defaults with the stale-while-revalidate and
+// stale-if-error directives, when present. Responses that must be revalidated
+// once stale are never served stale.
+func (cc *HeaderCacheControl) StalePolicy(defaults StalePolicy) StalePolicy {
+	if cc.mustRevalidateWhenStale() {
+		return StalePolicy{}
+	}
+
+	policy := defaults
+
+	if cc.StaleWhileRevalidate >= 0 {
+		policy.WhileRevalidate = time.Duration(cc.StaleWhileRevalidate) * time.Second
+	}
+
+	if cc.StaleIfError >= 0 {
+		policy.IfError = time.Duration(cc.StaleIfError) * time.Second
+	}
+
+	return policy
+}
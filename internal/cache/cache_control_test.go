@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -108,6 +110,8 @@ func TestIsCachable(t *testing.T) {
 		{"s-maxage=0", HeaderCacheControl{SMaxAge: 0}, false},
 		{"max-age>0", HeaderCacheControl{MaxAge: 100}, true},
 		{"s-maxage>0", HeaderCacheControl{SMaxAge: 200}, true},
+		{"no-cache with max-age=0", HeaderCacheControl{NoCache: true}, true},
+		{"no-cache with no-store", HeaderCacheControl{NoCache: true, NoStore: true}, false},
 	}
 
 	for _, tt := range tests {
@@ -154,6 +158,7 @@ func TestTTL(t *testing.T) {
 		{"max-age=60", HeaderCacheControl{MaxAge: 60, SMaxAge: -1}, true, 60 * time.Second},
 		{"s-maxage=120", HeaderCacheControl{MaxAge: -1, SMaxAge: 120}, true, 120 * time.Second},
 		{"s-maxage takes priority", HeaderCacheControl{MaxAge: 60, SMaxAge: 120}, true, 120 * time.Second},
+		{"no-cache is stale right away", HeaderCacheControl{NoCache: true, MaxAge: 60, SMaxAge: -1}, true, 0},
 	}
 
 	for _, tt := range tests {
@@ -168,3 +173,203 @@ func TestTTL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseStaleDirectives(t *testing.T) {
+	tests := []struct {
+		header               string
+		staleWhileRevalidate int64
+		staleIfError         int64
+	}{
+		{"", -1, -1},
+		{"max-age=60", -1, -1},
+		{"max-age=60, stale-while-revalidate=30", 30, -1},
+		{"stale-if-error=600", -1, 600},
+		{"Stale-While-Revalidate=5, STALE-IF-ERROR=10", 5, 10},
+		{"stale-while-revalidate=abc", -1, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			cc := ParseCacheControl(tt.header)
+			if cc.StaleWhileRevalidate != tt.staleWhileRevalidate {
+				t.Errorf("StaleWhileRevalidate = %d, expected %d", cc.StaleWhileRevalidate, tt.staleWhileRevalidate)
+			}
+			if cc.StaleIfError != tt.staleIfError {
+				t.Errorf("StaleIfError = %d, expected %d", cc.StaleIfError, tt.staleIfError)
+			}
+		})
+	}
+}
+
+func TestStalePolicy(t *testing.T) {
+	defaults := StalePolicy{WhileRevalidate: time.Minute, IfError: time.Hour}
+
+	if got := ParseCacheControl("max-age=60").StalePolicy(defaults); got != defaults {
+		t.Errorf("expected defaults, got %+v", got)
+	}
+
+	got := ParseCacheControl("stale-while-revalidate=0, stale-if-error=30").StalePolicy(defaults)
+	expected := StalePolicy{WhileRevalidate: 0, IfError: 30 * time.Second}
+	if got != expected {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestParseCacheControlDirectives(t *testing.T) {
+	cc := ParseCacheControl(`Public, must-revalidate, proxy-revalidate, no-transform, immutable, max-age="60", ` +
+		`private="Set-Cookie, x-user", no-cache="X-Debug", only-if-cached, max-stale=30, min-fresh=10`)
+
+	if !cc.Public || !cc.MustRevalidate || !cc.ProxyRevalidate || !cc.NoTransform || !cc.Immutable || !cc.OnlyIfCached {
+		t.Errorf("expected every boolean directive to be parsed, got %+v", cc)
+	}
+
+	if cc.Private || cc.NoCache {
+		t.Errorf("expected qualified private and no-cache not to apply to the whole response, got %+v", cc)
+	}
+
+	if len(cc.PrivateFields) != 2 || cc.PrivateFields[0] != "Set-Cookie" || cc.PrivateFields[1] != "X-User" {
+		t.Errorf("unexpected private fields %v", cc.PrivateFields)
+	}
+
+	if len(cc.NoCacheFields) != 1 || cc.NoCacheFields[0] != "X-Debug" {
+		t.Errorf("unexpected no-cache fields %v", cc.NoCacheFields)
+	}
+
+	if cc.MaxAge != 60 || cc.MaxStale != 30 || cc.MinFresh != 10 {
+		t.Errorf("unexpected delta seconds %+v", cc)
+	}
+
+	if ParseCacheControl("max-stale").MaxStale != AnyStale {
+		t.Error("expected max-stale without value to accept any staleness")
+	}
+
+	if ParseCacheControl("max-age=99999999999999999999").MaxAge != maxDeltaSeconds {
+		t.Error("expected overflowing delta seconds to be clamped")
+	}
+
+	if ParseCacheControl("max-age=-5").MaxAge != -1 {
+		t.Error("expected negative delta seconds to be ignored")
+	}
+}
+
+// TestCacheControlStorageConformance follows RFC 9111 §3 and §3.5 for a shared
+// cache.
+func TestCacheControlStorageConformance(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		header        map[string]string
+		authorization bool
+		storable      bool
+	}{
+		{"plain 200", 200, nil, false, true},
+		{"no-store", 200, map[string]string{"Cache-Control": "no-store"}, false, false},
+		{"private", 200, map[string]string{"Cache-Control": "private, max-age=60"}, false, false},
+		{"qualified private", 200, map[string]string{"Cache-Control": `private="Set-Cookie", max-age=60`}, false, true},
+		{"no-cache", 200, map[string]string{"Cache-Control": "no-cache"}, false, true},
+		{"qualified no-cache", 200, map[string]string{"Cache-Control": `no-cache="X-Debug"`}, false, true},
+		{"max-age=0", 200, map[string]string{"Cache-Control": "max-age=0, s-maxage=0"}, false, false},
+		{"non heuristic status", 500, nil, false, false},
+		{"non heuristic status with max-age", 500, map[string]string{"Cache-Control": "max-age=60"}, false, true},
+		{"non heuristic status with public", 302, map[string]string{"Cache-Control": "public"}, false, true},
+		{"non heuristic status with expires", 403, map[string]string{"Expires": "Thu, 01 Jan 2099 00:00:00 GMT"}, false, true},
+		{"not modified", 304, map[string]string{"Cache-Control": "public, max-age=60"}, false, false},
+		{"unknown status", 599, map[string]string{"Cache-Control": "max-age=60"}, false, false},
+		{"authorized without directive", 200, map[string]string{"Cache-Control": "max-age=60"}, true, false},
+		{"authorized public", 200, map[string]string{"Cache-Control": "public"}, true, true},
+		{"authorized must-revalidate", 200, map[string]string{"Cache-Control": "must-revalidate, max-age=60"}, true, true},
+		{"authorized s-maxage", 200, map[string]string{"Cache-Control": "s-maxage=60"}, true, true},
+		{"authorized public but private", 200, map[string]string{"Cache-Control": "public, private"}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := NewCachableResponse(httptest.NewRecorder())
+			for k, v := range tt.header {
+				cr.Header().Set(k, v)
+			}
+			cr.WriteHeader(tt.status)
+
+			storable := cr.IsCachable()
+			if tt.authorization {
+				storable = cr.IsCachableConsideringAuth()
+			}
+
+			if storable != tt.storable {
+				t.Errorf("storable = %v, expected %v", storable, tt.storable)
+			}
+		})
+	}
+}
+
+// TestCacheControlReuseConformance follows RFC 9111 §4.2, §5.2.1 and RFC 8246.
+func TestCacheControlReuseConformance(t *testing.T) {
+	fresh := time.Now().Add(time.Minute)
+	stale := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		response  string
+		request   string
+		storedAgo time.Duration
+		expiresAt time.Time
+		satisfies bool
+	}{
+		{"fresh", "max-age=120", "", time.Minute, fresh, true},
+		{"stale", "max-age=60", "", 2 * time.Minute, stale, false},
+		{"request no-cache", "max-age=120", "no-cache", time.Minute, fresh, false},
+		{"request no-cache on immutable", "max-age=120, immutable", "no-cache", time.Minute, fresh, true},
+		{"request no-cache on stale immutable", "max-age=60, immutable", "no-cache", 2 * time.Minute, stale, false},
+		{"request max-age satisfied", "max-age=120", "max-age=90", time.Minute, fresh, true},
+		{"request max-age too old", "max-age=120", "max-age=30", time.Minute, fresh, false},
+		{"request max-age=0 on immutable", "max-age=120, immutable", "max-age=0", time.Minute, fresh, true},
+		{"request min-fresh satisfied", "max-age=120", "min-fresh=30", time.Minute, fresh, true},
+		{"request min-fresh not satisfied", "max-age=120", "min-fresh=90", time.Minute, fresh, false},
+		{"request max-stale", "max-age=60", "max-stale=90", 2 * time.Minute, stale, true},
+		{"request max-stale exceeded", "max-age=60", "max-stale=30", 2 * time.Minute, stale, false},
+		{"request max-stale without value", "max-age=60", "max-stale", 2 * time.Minute, stale, true},
+		{"request max-stale on must-revalidate", "max-age=60, must-revalidate", "max-stale", 2 * time.Minute, stale, false},
+		{"request max-stale on proxy-revalidate", "max-age=60, proxy-revalidate", "max-stale", 2 * time.Minute, stale, false},
+		{"request max-stale on no-cache", "no-cache", "max-stale", 2 * time.Minute, stale, false},
+		{"request only-if-cached on fresh entry", "max-age=120", "only-if-cached", time.Minute, fresh, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := Entry{
+				StatusCode: 200,
+				Header:     http.Header{"Cache-Control": []string{tt.response}},
+				ExpiresAt:  tt.expiresAt,
+				StoredAt:   time.Now().Add(-tt.storedAgo),
+			}
+
+			if got := entry.Satisfies(ParseCacheControl(tt.request)); got != tt.satisfies {
+				t.Errorf("Satisfies() = %v, expected %v", got, tt.satisfies)
+			}
+		})
+	}
+}
+
+func TestParseRequestCacheControlPragma(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Pragma", "no-cache")
+
+	if !ParseRequestCacheControl(req).NoCache {
+		t.Error("expected Pragma: no-cache to be honored without Cache-Control")
+	}
+
+	req.Header.Set("Cache-Control", "max-age=60")
+	if ParseRequestCacheControl(req).NoCache {
+		t.Error("expected Cache-Control to take precedence over Pragma")
+	}
+}
+
+func TestMustRevalidateDisablesStaleServing(t *testing.T) {
+	defaults := StalePolicy{WhileRevalidate: time.Minute, IfError: time.Minute}
+
+	for _, header := range []string{"must-revalidate, stale-if-error=60", "proxy-revalidate", "no-cache"} {
+		if got := ParseCacheControl(header).StalePolicy(defaults); got != (StalePolicy{}) {
+			t.Errorf("%s: expected no stale serving, got %+v", header, got)
+		}
+	}
+}
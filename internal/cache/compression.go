@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoding is a content coding the cache can store bodies with.
+type Encoding string
+
+const (
+	EncodingIdentity Encoding = ""
+	EncodingGzip     Encoding = "gzip"
+	EncodingBrotli   Encoding = "br"
+	EncodingZstd     Encoding = "zstd"
+)
+
+// minCompressedBodySize is the size under which bodies are stored as is, the
+// encoding overhead outweighs the savings.
+const minCompressedBodySize = 256
+
+func ParseEncoding(name string) (Encoding, error) {
+	switch encoding := Encoding(strings.ToLower(name)); encoding {
+	case EncodingIdentity, EncodingGzip, EncodingBrotli, EncodingZstd:
+		return encoding, nil
+	default:
+		return EncodingIdentity, fmt.Errorf("unknown cache compression %s", name)
+	}
+}
+
+// compressedWith returns the entry with its body encoded, unless the response
+// forbids transformations, is already encoded, or does not shrink.
+func (entry Entry) compressedWith(encoding Encoding) Entry {
+	if encoding == EncodingIdentity || entry.Encoding != EncodingIdentity || len(entry.Body) < minCompressedBodySize {
+		return entry
+	}
+
+	if entry.Header.Get("Content-Encoding") != "" || entry.Header.Get("Content-Range") != "" ||
+		ParseCacheControl(entry.Header.Get("Cache-Control")).NoTransform {
+		return entry
+	}
+
+	compressed, err := compress(encoding, entry.Body)
+	if err != nil || len(compressed) >= len(entry.Body) {
+		return entry
+	}
+
+	entry.Body = compressed
+	entry.Encoding = encoding
+
+	return entry
+}
+
+func compress(encoding Encoding, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	case EncodingZstd:
+		encoder, err := zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		w = encoder
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", encoding)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// decompress writes the decoded form of body to w.
+func decompress(w io.Writer, encoding Encoding, body []byte) error {
+	var r io.Reader
+	switch encoding {
+	case EncodingGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case EncodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	case EncodingZstd:
+		decoder, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		r = decoder
+	default:
+		return fmt.Errorf("unknown encoding %s", encoding)
+	}
+
+	_, err := io.Copy(w, r)
+
+	return err
+}
+
+// writeEncodedBody serves a compressed body as is to clients accepting its
+// encoding, and decompresses it on the fly for the others.
+func (entry *Entry) writeEncodedBody(w http.ResponseWriter, r *http.Request) error {
+	h := w.Header()
+	addVary(h, "Accept-Encoding")
+
+	if !acceptsEncoding(r, entry.Encoding) {
+		w.WriteHeader(entry.StatusCode)
+		if r.Method == http.MethodHead {
+			return nil
+		}
+
+		return decompress(w, entry.Encoding, entry.Body)
+	}
+
+	h.Set("Content-Encoding", string(entry.Encoding))
+	h.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	// The encoded form is a different representation, byte for byte.
+	if etag := h.Get("ETag"); etag != "" && !isWeakETag(etag) {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	w.WriteHeader(entry.StatusCode)
+	_, err := w.Write(entry.Body)
+
+	return err
+}
+
+// acceptsEncoding tells if the Accept-Encoding header of r allows encoding,
+// explicitly or through the * wildcard (RFC 9110 §12.5.3).
+func acceptsEncoding(r *http.Request, encoding Encoding) bool {
+	wildcard := false
+	for _, field := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(field, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+
+			switch {
+			case coding == string(encoding) || (encoding == EncodingGzip && coding == "x-gzip"):
+				return qualityOf(params) > 0
+			case coding == "*":
+				wildcard = qualityOf(params) > 0
+			}
+		}
+	}
+
+	return wildcard
+}
+
+func qualityOf(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(name, "q") {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+
+	return 1
+}
+
+func addVary(h http.Header, name string) {
+	names, _ := parseVary(h)
+	if !slices.Contains(names, name) {
+		h.Add("Vary", name)
+	}
+}
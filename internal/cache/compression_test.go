@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var compressibleBody = []byte(strings.Repeat("cache me if you can, ", 100))
+
+func newCompressingCache(t *testing.T, encoding Encoding) *HttpCache {
+	c := NewEmptyCacheWithOptions(1, 1, Options{Compression: encoding})
+	t.Cleanup(func() { c.Cleanup() })
+	return c
+}
+
+func setCompressibleEntry(c *HttpCache, header http.Header, body []byte) *http.Request {
+	resp := NewCachableResponse(httptest.NewRecorder())
+	for name, values := range header {
+		resp.Header()[name] = values
+	}
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(body)
+
+	req := httptest.NewRequest("GET", "http://example.com/compressed", nil)
+	c.Set(req, resp, time.Now().Add(ttl))
+
+	return req
+}
+
+func serveHit(t *testing.T, c *HttpCache, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "http://example.com/compressed", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	entry, found := c.Get(req)
+	if !found {
+		t.Fatal("expected entry to be found")
+	}
+
+	w := httptest.NewRecorder()
+	if err := entry.WriteResponse(w, req); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+
+	return w
+}
+
+func TestCompressedEntries(t *testing.T) {
+	for _, encoding := range []Encoding{EncodingGzip, EncodingBrotli, EncodingZstd} {
+		t.Run(string(encoding), func(t *testing.T) {
+			c := newCompressingCache(t, encoding)
+			req := setCompressibleEntry(c, http.Header{"Etag": {`"v1"`}}, compressibleBody)
+
+			stored, _ := storedEntry(c, c.KeyFor(req))
+			if stored.Encoding != encoding || len(stored.Body) >= len(compressibleBody) {
+				t.Fatalf("expected body to be stored with %s, got %q in %d bytes", encoding, stored.Encoding, len(stored.Body))
+			}
+			if c.CurrentSize() != len(stored.Body) {
+				t.Errorf("expected the stored size to be accounted, got %d for %d", c.CurrentSize(), len(stored.Body))
+			}
+
+			encoded := serveHit(t, c, "deflate, "+string(encoding)+";q=0.8")
+			if encoded.Header().Get("Content-Encoding") != string(encoding) || !bytes.Equal(encoded.Body.Bytes(), stored.Body) {
+				t.Errorf("expected the encoded body to be served as is, got %q", encoded.Header().Get("Content-Encoding"))
+			}
+			if encoded.Header().Get("ETag") != `W/"v1"` || encoded.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("unexpected headers %v", encoded.Header())
+			}
+
+			var decoded bytes.Buffer
+			if err := decompress(&decoded, encoding, encoded.Body.Bytes()); err != nil || !bytes.Equal(decoded.Bytes(), compressibleBody) {
+				t.Errorf("expected the encoded body to decode to the original one, got error %v", err)
+			}
+
+			identity := serveHit(t, c, "")
+			if identity.Header().Get("Content-Encoding") != "" || !bytes.Equal(identity.Body.Bytes(), compressibleBody) {
+				t.Error("expected the body to be decompressed for clients not accepting the encoding")
+			}
+			if identity.Header().Get("ETag") != `"v1"` {
+				t.Errorf("expected the identity form to keep its ETag, got %q", identity.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestCompressionSkipsUnsuitableBodies(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+	}{
+		{"small body", nil, []byte("tiny")},
+		{"no-transform", http.Header{"Cache-Control": {"max-age=60, no-transform"}}, compressibleBody},
+		{"already encoded", http.Header{"Content-Encoding": {"gzip"}}, compressibleBody},
+		{"incompressible", nil, randomBody(4096)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCompressingCache(t, EncodingGzip)
+			req := setCompressibleEntry(c, tt.header, tt.body)
+
+			stored, _ := storedEntry(c, c.KeyFor(req))
+			if stored.Encoding != EncodingIdentity || !bytes.Equal(stored.Body, tt.body) {
+				t.Errorf("expected body to be stored as is, got %q", stored.Encoding)
+			}
+		})
+	}
+}
+
+func randomBody(size int) []byte {
+	body := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(body)
+
+	return body
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encoding       Encoding
+		expected       bool
+	}{
+		{"", EncodingGzip, false},
+		{"gzip", EncodingGzip, true},
+		{"x-gzip", EncodingGzip, true},
+		{"GZIP;q=0.5", EncodingGzip, true},
+		{"gzip;q=0", EncodingGzip, false},
+		{"deflate, br", EncodingBrotli, true},
+		{"*", EncodingZstd, true},
+		{"zstd;q=0, *", EncodingZstd, false},
+		{"gzip, *;q=0", EncodingZstd, false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+		if got := acceptsEncoding(req, tt.encoding); got != tt.expected {
+			t.Errorf("acceptsEncoding(%q, %s) = %v, expected %v", tt.acceptEncoding, tt.encoding, got, tt.expected)
+		}
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	for _, name := range []string{"", "gzip", "br", "zstd"} {
+		if encoding, err := ParseEncoding(name); err != nil || string(encoding) != name {
+			t.Errorf("ParseEncoding(%q) = %q, %v", name, encoding, err)
+		}
+	}
+
+	if _, err := ParseEncoding("deflate"); err == nil {
+		t.Error("expected an error for an unsupported encoding")
+	}
+}
+
+func TestCompressedEntriesSurviveSnapshots(t *testing.T) {
+	c := newCompressingCache(t, EncodingZstd)
+	setCompressibleEntry(c, nil, compressibleBody)
+
+	var snapshot bytes.Buffer
+	if _, err := c.WriteSnapshot(&snapshot); err != nil {
+		t.Fatalf("WriteSnapshot returned error: %v", err)
+	}
+
+	restored := newEmptyConfiguredCache(t)
+	if _, err := restored.ReadSnapshot(&snapshot); err != nil {
+		t.Fatalf("ReadSnapshot returned error: %v", err)
+	}
+
+	if w := serveHit(t, restored, ""); !bytes.Equal(w.Body.Bytes(), compressibleBody) {
+		t.Error("expected the restored entry to keep its encoding")
+	}
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// evaluatePreconditions applies RFC 9110 §13.2.2 to a cached response. It
+// returns the status to answer with instead of the stored response, or 0 when
+// the stored response should be sent as is.
+func evaluatePreconditions(r *http.Request, h http.Header) int {
+	if r == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return 0
+	}
+
+	etag := h.Get("ETag")
+	lastModified, hasLastModified := parseHTTPDate(h.Get("Last-Modified"))
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(r.Header.Get("If-Unmodified-Since")); ok && hasLastModified {
+		if lastModified.After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			return http.StatusNotModified
+		}
+	} else if since, ok := parseHTTPDate(r.Header.Get("If-Modified-Since")); ok && hasLastModified {
+		if !lastModified.After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+func etagListMatches(list string, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range splitQuotedList(list) {
+		if strong && etagsMatchStrong(candidate, etag) {
+			return true
+		}
+		if !strong && etagsMatchWeak(candidate, etag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+func etagsMatchStrong(a, b string) bool {
+	return !isWeakETag(a) && !isWeakETag(b) && a == b
+}
+
+func etagsMatchWeak(a, b string) bool {
+	return opaqueTag(a) == opaqueTag(b)
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
+
+func writeNotModified(w http.ResponseWriter, entry *Entry) {
+	h := entry.Header
+	dst := w.Header()
+	for key, values := range h {
+		switch key {
+		case "Content-Type", "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding":
+			continue
+		case "Last-Modified":
+			if h.Get("ETag") != "" {
+				continue
+			}
+		}
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+
+	writeAgeHeader(w, entry)
+	w.WriteHeader(http.StatusNotModified)
+}
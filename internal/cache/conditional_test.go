@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvaluatePreconditions(t *testing.T) {
+	lastModified := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	before := lastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := lastModified.Add(time.Hour).Format(http.TimeFormat)
+
+	entryHeader := http.Header{
+		"Etag":          []string{`"v1"`},
+		"Last-Modified": []string{lastModified.Format(http.TimeFormat)},
+	}
+	weakHeader := http.Header{"Etag": []string{`W/"v1"`}}
+
+	tests := []struct {
+		name     string
+		method   string
+		header   http.Header
+		request  map[string]string
+		expected int
+	}{
+		{"no conditionals", "GET", entryHeader, nil, 0},
+		{"if-none-match strong hit", "GET", entryHeader, map[string]string{"If-None-Match": `"v1"`}, http.StatusNotModified},
+		{"if-none-match weak request", "GET", entryHeader, map[string]string{"If-None-Match": `W/"v1"`}, http.StatusNotModified},
+		{"if-none-match weak entry", "GET", weakHeader, map[string]string{"If-None-Match": `"v1"`}, http.StatusNotModified},
+		{"if-none-match list", "GET", entryHeader, map[string]string{"If-None-Match": `"a,b", "v1"`}, http.StatusNotModified},
+		{"if-none-match star", "HEAD", entryHeader, map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"if-none-match miss", "GET", entryHeader, map[string]string{"If-None-Match": `"v2"`}, 0},
+		{"if-none-match wins over if-modified-since", "GET", entryHeader, map[string]string{"If-None-Match": `"v2"`, "If-Modified-Since": after}, 0},
+		{"if-modified-since not modified", "GET", entryHeader, map[string]string{"If-Modified-Since": after}, http.StatusNotModified},
+		{"if-modified-since same date", "GET", entryHeader, map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"if-modified-since modified", "GET", entryHeader, map[string]string{"If-Modified-Since": before}, 0},
+		{"if-modified-since invalid date", "GET", entryHeader, map[string]string{"If-Modified-Since": "yesterday"}, 0},
+		{"if-modified-since without last-modified", "GET", weakHeader, map[string]string{"If-Modified-Since": after}, 0},
+		{"if-match strong hit", "GET", entryHeader, map[string]string{"If-Match": `"v1"`}, 0},
+		{"if-match weak entry", "GET", weakHeader, map[string]string{"If-Match": `"v1"`}, http.StatusPreconditionFailed},
+		{"if-match miss", "GET", entryHeader, map[string]string{"If-Match": `"v2"`}, http.StatusPreconditionFailed},
+		{"if-match star", "GET", entryHeader, map[string]string{"If-Match": "*"}, 0},
+		{"if-unmodified-since modified", "GET", entryHeader, map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+		{"if-unmodified-since unmodified", "GET", entryHeader, map[string]string{"If-Unmodified-Since": after}, 0},
+		{"if-match wins over if-unmodified-since", "GET", entryHeader, map[string]string{"If-Match": `"v1"`, "If-Unmodified-Since": before}, 0},
+		{"failed precondition wins over not modified", "GET", entryHeader, map[string]string{"If-Match": `"v2"`, "If-None-Match": `"v1"`}, http.StatusPreconditionFailed},
+		{"ignored on other methods", "POST", entryHeader, map[string]string{"If-None-Match": `"v1"`}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.request {
+				req.Header.Set(k, v)
+			}
+
+			if got := evaluatePreconditions(req, tt.header); got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWriteResponseNotModified(t *testing.T) {
+	entry := Entry{
+		StatusCode: 200,
+		Body:       []byte("Hello World"),
+		Header: http.Header{
+			"Etag":           []string{`"v1"`},
+			"Cache-Control":  []string{"max-age=60"},
+			"Content-Type":   []string{"text/plain"},
+			"Content-Length": []string{"11"},
+			"X-Cache":        []string{"HIT"},
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `W/"v1"`)
+	rec := httptest.NewRecorder()
+
+	if err := entry.WriteResponse(rec, req); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected status 304, got %d", rec.Code)
+	}
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", rec.Body.String())
+	}
+
+	if rec.Header().Get("ETag") != `"v1"` || rec.Header().Get("Cache-Control") != "max-age=60" || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected validators and cache headers to be kept, got %v", rec.Header())
+	}
+
+	if rec.Header().Get("Content-Type") != "" || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("expected content headers to be dropped, got %v", rec.Header())
+	}
+}
+
+func TestWriteResponsePreconditionFailed(t *testing.T) {
+	entry := Entry{
+		StatusCode: 200,
+		Body:       []byte("Hello World"),
+		Header:     http.Header{"Etag": []string{`"v1"`}},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Match", `"v2"`)
+	rec := httptest.NewRecorder()
+
+	if err := entry.WriteResponse(rec, req); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status 412, got %d", rec.Code)
+	}
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", rec.Body.String())
+	}
+}
+
+func TestWriteResponseIgnoresConditionalsOnErrors(t *testing.T) {
+	entry := Entry{
+		StatusCode: 404,
+		Body:       []byte("not found"),
+		Header:     http.Header{"Etag": []string{`"v1"`}},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	rec := httptest.NewRecorder()
+
+	if err := entry.WriteResponse(rec, req); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "not found" {
+		t.Errorf("expected the stored 404, got %d %q", rec.Code, rec.Body.String())
+	}
+}
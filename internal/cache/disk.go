@@ -0,0 +1,459 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const diskFormatVersion = 1
+
+// maxDiskPendingSize bounds the bodies held in memory until the background
+// writer catches up, entries staged beyond it are refused. A single entry is
+// always accepted.
+const maxDiskPendingSize = 64 << 20
+
+// DiskStore is a second cache tier keeping entries in content addressed files,
+// named after the SHA-256 of their content. Writes go to a temporary file
+// renamed once synced, so a crash never leaves a partial entry behind, and the
+// index is rebuilt from the files on startup.
+type DiskStore struct {
+	dir         string
+	maxSize     int // in bytes
+	currentSize int // in bytes
+	pendingSize int // in bytes, bodies waiting to be written
+	records     map[Key]*diskRecord
+	policy      EvictionPolicy
+	wake        chan struct{}
+	done        chan struct{}
+	stopped     chan struct{}
+	closeOnce   sync.Once
+	sync.Mutex
+}
+
+type diskRecord struct {
+	key     Key
+	primary Key   // key of the resource, differs from key for Vary variants
+	meta    Entry // entry without its body
+	size    int
+	name    string // content address, empty until written
+	pending *Entry // entry waiting to be written
+	writing bool
+}
+
+// diskHeader is the first value of an entry file, decoded alone when the
+// index is rebuilt without reading the body, which follows as a diskBody.
+type diskHeader struct {
+	Version int
+	Key     Key
+	Primary Key
+	Size    int
+	Entry   Entry
+}
+
+type diskBody struct {
+	Body []byte
+}
+
+func NewDiskStore(dir string, maxSizeMiB int) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	d := &DiskStore{
+		dir:     dir,
+		maxSize: MiBToBytes(maxSizeMiB),
+		records: make(map[Key]*diskRecord),
+		policy:  NewLRUPolicy(),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if err := d.rebuild(); err != nil {
+		return nil, err
+	}
+
+	go d.writeLoop()
+
+	return d, nil
+}
+
+// Close stops the background writer once pending entries are written.
+func (d *DiskStore) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		<-d.stopped
+	})
+
+	return d.Flush()
+}
+
+// Flush writes the pending entries to disk.
+func (d *DiskStore) Flush() error {
+	d.Lock()
+	var batch []*diskRecord
+	for _, rec := range d.records {
+		if rec.pending != nil && !rec.writing {
+			rec.writing = true
+			batch = append(batch, rec)
+		}
+	}
+	d.Unlock()
+
+	var errs []error
+	for _, rec := range batch {
+		name, err := d.write(rec.key, rec.primary, *rec.pending)
+
+		d.Lock()
+		current := d.records[rec.key] == rec
+		rec.writing = false
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+			if current {
+				d.removeLocked(rec.key)
+			}
+		case current:
+			rec.name = name
+			rec.pending = nil
+			d.pendingSize -= rec.size
+		}
+		d.Unlock()
+
+		// The entry was replaced or deleted while being written.
+		if err == nil && !current {
+			_ = os.Remove(d.path(name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *DiskStore) writeLoop() {
+	defer close(d.stopped)
+
+	for {
+		select {
+		case <-d.wake:
+			_ = d.Flush()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *DiskStore) kick() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stage records an entry to be written by the background writer, least
+// recently used entries are evicted to stay within the size budget and
+// returned without their bodies. Entries are refused while the writer lags
+// behind by more than maxDiskPendingSize.
+func (d *DiskStore) stage(key, primary Key, entry Entry) (map[Key]Entry, bool) {
+	size := len(entry.Body)
+	if size > d.maxSize {
+		return nil, false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.removeLocked(key)
+
+	if d.pendingSize > 0 && d.pendingSize+size > maxDiskPendingSize {
+		return nil, false
+	}
+
+	evicted := make(map[Key]Entry)
+	for d.currentSize+size > d.maxSize {
+		victim, found := d.policy.Evict(key, size)
+		if !found || victim == key {
+			d.policy.Remove(key)
+			return evicted, false
+		}
+
+		if rec, exists := d.records[victim]; exists {
+			evicted[victim] = rec.meta
+		}
+		d.removeLocked(victim)
+	}
+
+	meta := entry
+	meta.Body = nil
+	d.records[key] = &diskRecord{key: key, primary: primary, meta: meta, size: size, pending: &entry}
+	d.currentSize += size
+	d.pendingSize += size
+	d.policy.Add(key, size)
+	d.kick()
+
+	return evicted, true
+}
+
+// load reads an entry, files not matching their content address are dropped.
+func (d *DiskStore) load(key Key) (Entry, bool) {
+	d.Lock()
+	rec, exists := d.records[key]
+	if !exists {
+		d.Unlock()
+		return Entry{}, false
+	}
+	d.policy.Touch(key)
+	if rec.pending != nil {
+		entry := *rec.pending
+		d.Unlock()
+		return entry, true
+	}
+	name := rec.name
+	d.Unlock()
+
+	_, entry, err := d.read(name, true)
+	if err != nil {
+		d.Lock()
+		if d.records[key] == rec {
+			d.removeLocked(key)
+		}
+		d.Unlock()
+		return Entry{}, false
+	}
+
+	return entry, true
+}
+
+// peek returns the stored entry without its body.
+func (d *DiskStore) peek(key Key) (Entry, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	rec, exists := d.records[key]
+	if !exists {
+		return Entry{}, false
+	}
+
+	return rec.meta, true
+}
+
+// remove deletes an entry and returns it without its body.
+func (d *DiskStore) remove(key Key) (Entry, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	rec, exists := d.records[key]
+	if !exists {
+		return Entry{}, false
+	}
+	d.removeLocked(key)
+
+	return rec.meta, true
+}
+
+func (d *DiskStore) removeLocked(key Key) {
+	rec, exists := d.records[key]
+	if !exists {
+		return
+	}
+
+	delete(d.records, key)
+	d.policy.Remove(key)
+	d.currentSize -= rec.size
+	if rec.pending != nil {
+		d.pendingSize -= rec.size
+	}
+	if rec.name != "" {
+		_ = os.Remove(d.path(rec.name))
+	}
+}
+
+// each calls fn with every stored entry, without its body.
+func (d *DiskStore) each(fn func(key, primary Key, meta Entry)) {
+	d.Lock()
+	records := make([]diskRecord, 0, len(d.records))
+	for _, rec := range d.records {
+		records = append(records, *rec)
+	}
+	d.Unlock()
+
+	for _, rec := range records {
+		fn(rec.key, rec.primary, rec.meta)
+	}
+}
+
+func (d *DiskStore) stats() (int, int) {
+	d.Lock()
+	defer d.Unlock()
+
+	return len(d.records), d.currentSize
+}
+
+func (d *DiskStore) path(name string) string {
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+func (d *DiskStore) write(key, primary Key, entry Entry) (string, error) {
+	meta := entry
+	meta.Body = nil
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(diskHeader{Version: diskFormatVersion, Key: key, Primary: primary, Size: len(entry.Body), Entry: meta}); err != nil {
+		return "", err
+	}
+	if err := enc.Encode(diskBody{Body: entry.Body}); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	name := hex.EncodeToString(sum[:])
+
+	return name, d.writeFile(d.path(name), buf.Bytes())
+}
+
+func (d *DiskStore) writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(d.dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// read decodes an entry file, streaming it so that the body is only read,
+// decoded and checked against the content address when withBody is set.
+func (d *DiskStore) read(name string, withBody bool) (diskHeader, Entry, error) {
+	var header diskHeader
+
+	f, err := os.Open(d.path(name))
+	if err != nil {
+		return header, Entry{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	var r io.Reader = f
+	if withBody {
+		r = io.TeeReader(f, hash)
+	}
+
+	dec := gob.NewDecoder(r)
+	if err := dec.Decode(&header); err != nil {
+		return header, Entry{}, err
+	}
+	if header.Version != diskFormatVersion {
+		return header, Entry{}, fmt.Errorf("disk entry %s: unsupported version %d", name, header.Version)
+	}
+
+	entry := header.Entry
+	if !withBody {
+		return header, entry, nil
+	}
+
+	var body diskBody
+	if err := dec.Decode(&body); err != nil {
+		return header, Entry{}, err
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return header, Entry{}, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != name {
+		return header, Entry{}, fmt.Errorf("disk entry %s: checksum mismatch", name)
+	}
+	entry.Body = body.Body
+
+	return header, entry, nil
+}
+
+// rebuild indexes the entry files left by a previous run, removing temporary
+// files of interrupted writes and files that cannot be decoded.
+func (d *DiskStore) rebuild() error {
+	var records []*diskRecord
+
+	err := filepath.WalkDir(d.dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			return os.Remove(path)
+		}
+		if !isContentAddress(name) || path != d.path(name) {
+			return nil
+		}
+
+		header, meta, err := d.read(name, false)
+		if err != nil {
+			return os.Remove(path)
+		}
+
+		records = append(records, &diskRecord{key: header.Key, primary: header.Primary, meta: meta, size: header.Size, name: name})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Older entries are the first to be evicted.
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].meta.StoredAt.Before(records[j].meta.StoredAt)
+	})
+
+	for _, rec := range records {
+		d.removeLocked(rec.key)
+		d.records[rec.key] = rec
+		d.currentSize += rec.size
+		d.policy.Add(rec.key, rec.size)
+	}
+
+	for d.currentSize > d.maxSize {
+		victim, found := d.policy.Evict("", 0)
+		if !found {
+			break
+		}
+		d.removeLocked(victim)
+	}
+
+	return nil
+}
+
+func isContentAddress(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDiskStore(t *testing.T, dir string, maxSize int) *DiskStore {
+	d, err := NewDiskStore(dir, 1)
+	if err != nil {
+		t.Fatalf("NewDiskStore returned error: %v", err)
+	}
+	d.maxSize = maxSize
+	t.Cleanup(func() { d.Close() })
+
+	return d
+}
+
+func newTieredCache(t *testing.T, dir string, memorySize, diskSize int) *HttpCache {
+	c := newUnmanagedTieredCache(t, dir, memorySize, diskSize)
+	t.Cleanup(func() { c.Cleanup() })
+
+	return c
+}
+
+func newUnmanagedTieredCache(t *testing.T, dir string, memorySize, diskSize int) *HttpCache {
+	c := NewEmptyCacheWithOptions(1, 1, Options{Disk: newTestDiskStore(t, dir, diskSize)})
+	c.MaxSize = memorySize
+	c.MaxEntrySize = memorySize
+
+	return c
+}
+
+func diskEntry(body string) Entry {
+	return Entry{
+		Path:       "/" + body,
+		StatusCode: http.StatusOK,
+		Body:       []byte(body),
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		ExpiresAt:  time.Now().Add(ttl),
+		StoredAt:   time.Now(),
+	}
+}
+
+func entryFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, e os.DirEntry, err error) error {
+		if err == nil && !e.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("walking %s: %v", dir, err)
+	}
+
+	return files
+}
+
+func TestDiskStoreWritesContentAddressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDiskStore(t, dir, 1024)
+
+	if _, stored := d.stage("k", "k", diskEntry("hello")); !stored {
+		t.Fatal("expected entry to be staged")
+	}
+	if entry, found := d.load("k"); !found || string(entry.Body) != "hello" {
+		t.Fatal("expected pending entry to be readable before it is written")
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	files := entryFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("expected a single entry file, got %v", files)
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if name := hex.EncodeToString(sum[:]); filepath.Base(files[0]) != name || filepath.Base(filepath.Dir(files[0])) != name[:2] {
+		t.Errorf("expected file to be named after its content, got %s", files[0])
+	}
+
+	entry, found := d.load("k")
+	if !found || string(entry.Body) != "hello" || entry.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("expected entry to be read back from disk, got %+v", entry)
+	}
+}
+
+func TestDiskStoreRebuildsIndex(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDiskStore(t, dir, 1024)
+	d.stage("a", "a", diskEntry("first"))
+	d.stage("b", "b", diskEntry("second"))
+	d.Close()
+
+	// Leftovers of an interrupted write and an unreadable entry file.
+	if err := os.WriteFile(filepath.Join(dir, "entry-1.tmp"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	garbage := hex.EncodeToString(make([]byte, sha256.Size))
+	if err := os.MkdirAll(filepath.Join(dir, garbage[:2]), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, garbage[:2], garbage), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt := newTestDiskStore(t, dir, 1024)
+	if entries, size := rebuilt.stats(); entries != 2 || size != len("first")+len("second") {
+		t.Errorf("expected 2 entries of %d bytes, got %d entries of %d bytes", len("first")+len("second"), entries, size)
+	}
+	if entry, found := rebuilt.load("b"); !found || string(entry.Body) != "second" {
+		t.Error("expected entry to survive a restart")
+	}
+	if files := entryFiles(t, dir); len(files) != 2 {
+		t.Errorf("expected temporary and unreadable files to be removed, got %v", files)
+	}
+}
+
+func TestDiskStoreDropsCorruptedEntries(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDiskStore(t, dir, 1024)
+	d.stage("k", "k", diskEntry("hello"))
+	d.Flush()
+
+	files := entryFiles(t, dir)
+	if err := os.WriteFile(files[0], []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := d.load("k"); found {
+		t.Error("expected entry not matching its content address to be dropped")
+	}
+	if entries, _ := d.stats(); entries != 0 {
+		t.Errorf("expected corrupted entry to be forgotten, got %d entries", entries)
+	}
+}
+
+func TestDiskStoreEnforcesItsBudget(t *testing.T) {
+	dir := t.TempDir()
+	d := newTestDiskStore(t, dir, 10)
+
+	d.stage("a", "a", diskEntry("aaaa"))
+	d.stage("b", "b", diskEntry("bbbb"))
+	d.load("a")
+	evicted, stored := d.stage("c", "c", diskEntry("cccc"))
+
+	if !stored {
+		t.Fatal("expected entry to be stored")
+	}
+	if _, found := evicted["b"]; len(evicted) != 1 || !found {
+		t.Errorf("expected least recently used entry to be evicted, got %v", evicted)
+	}
+	if _, stored := d.stage("big", "big", diskEntry("more than ten bytes")); stored {
+		t.Error("expected entry larger than the disk budget to be refused")
+	}
+	d.Flush()
+	if files := entryFiles(t, dir); len(files) != 2 {
+		t.Errorf("expected 2 entry files, got %v", files)
+	}
+}
+
+func TestDiskStoreRefusesEntriesWhileTheWriterLags(t *testing.T) {
+	d := newTestDiskStore(t, t.TempDir(), 2*maxDiskPendingSize)
+	d.Close() // entries stay pending without the background writer
+
+	half := Entry{StatusCode: http.StatusOK, Body: make([]byte, maxDiskPendingSize/2)}
+	if _, stored := d.stage("a", "a", half); !stored {
+		t.Fatal("expected entry to be staged")
+	}
+	if _, stored := d.stage("b", "b", Entry{StatusCode: http.StatusOK, Body: make([]byte, maxDiskPendingSize)}); stored {
+		t.Error("expected entry exceeding the pending budget to be refused")
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if _, stored := d.stage("b", "b", Entry{StatusCode: http.StatusOK, Body: make([]byte, maxDiskPendingSize)}); !stored {
+		t.Error("expected entry to be staged once pending entries are written")
+	}
+}
+
+func TestTieredCacheDemotesAndPromotes(t *testing.T) {
+	c := newTieredCache(t, t.TempDir(), 30, 100)
+
+	first := setTaggedEntry(c, "http://example.com/one", nil)
+	second := setTaggedEntry(c, "http://example.com/two", nil)
+
+	if _, inMemory := storedEntry(c, c.KeyFor(first)); inMemory {
+		t.Fatal("expected the least recently used entry to leave memory")
+	}
+	if stats := c.Stats(); stats.DiskEntries != 1 {
+		t.Fatalf("expected evicted entry to be demoted to disk, got %+v", stats)
+	}
+
+	entry, found := c.Get(first)
+	if !found || string(entry.Body) != "http://example.com/one" {
+		t.Fatal("expected demoted entry to be served from disk")
+	}
+	if _, inMemory := storedEntry(c, c.KeyFor(first)); !inMemory {
+		t.Error("expected entry read from disk to be promoted")
+	}
+	if _, inMemory := storedEntry(c, c.KeyFor(second)); inMemory {
+		t.Error("expected promotion to demote the other entry")
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.DiskEntries != 1 {
+		t.Errorf("expected one entry per tier, got %+v", stats)
+	}
+}
+
+func TestTieredCacheStoresOversizedEntriesOnDisk(t *testing.T) {
+	c := newTieredCache(t, t.TempDir(), 10, 100)
+
+	r := setTaggedEntry(c, "http://example.com/a-body-larger-than-memory", map[string]string{"Surrogate-Key": "large"})
+
+	if c.Stats().Entries != 0 {
+		t.Fatal("expected oversized entry to stay out of memory")
+	}
+	entry, found := c.Get(r)
+	if !found || string(entry.Body) != "http://example.com/a-body-larger-than-memory" {
+		t.Fatal("expected oversized entry to be served from disk")
+	}
+
+	purged, _ := c.Purge(PurgeSelector{Tags: []string{"large"}})
+	if purged != 1 {
+		t.Errorf("expected disk entry to be purged by tag, got %d", purged)
+	}
+	if _, found := c.Get(r); found {
+		t.Error("expected purged entry to be gone from disk")
+	}
+}
+
+func TestTieredCacheSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	c := newUnmanagedTieredCache(t, dir, 5, 100)
+
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"}), "Accept-Encoding", "gzipped")
+	setTaggedEntry(c, "http://example.com/kept", map[string]string{"Cache-Tag": "kept"})
+	c.Cleanup()
+
+	restarted := newTieredCache(t, dir, 5, 100)
+
+	entry, found := restarted.Get(requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"}))
+	if !found || string(entry.Body) != "gzipped" {
+		t.Error("expected variant to be served after a restart")
+	}
+	if _, found := restarted.Get(requestWithHeaders(map[string]string{"Accept-Encoding": "br"})); found {
+		t.Error("expected the variant index to be rebuilt after a restart")
+	}
+	if purged, _ := restarted.Purge(PurgeSelector{Tags: []string{"kept"}}); purged != 1 {
+		t.Errorf("expected tags to be rebuilt after a restart, got %d purged", purged)
+	}
+}
@@ -0,0 +1,61 @@
+package cache
+
+import "fmt"
+
+type EvictionPolicyType string
+
+const (
+	EvictionLRU           EvictionPolicyType = "lru"
+	EvictionLFU           EvictionPolicyType = "lfu"
+	EvictionARC           EvictionPolicyType = "arc"
+	EvictionWTinyLFU      EvictionPolicyType = "w-tinylfu"
+	DefaultEvictionPolicy                    = EvictionLRU
+)
+
+// EvictionPolicy decides which entries leave a full cache. Implementations are
+// not safe for concurrent use, HttpCache only calls them under its write lock.
+type EvictionPolicy interface {
+	// Add records a key stored in the cache.
+	Add(key Key, size int)
+	// Touch records a cache hit on key.
+	Touch(key Key)
+	// Remove forgets a key deleted from the cache.
+	Remove(key Key)
+	// Evict picks and forgets a key to make room for incoming. Returning
+	// incoming itself means the policy refuses to admit it.
+	Evict(incoming Key, incomingSize int) (Key, bool)
+}
+
+// orderedPolicy is implemented by policies able to list their keys from the
+// first to the last they would evict.
+type orderedPolicy interface {
+	evictionOrder() []Key
+}
+
+func NewEvictionPolicy(policyType EvictionPolicyType) (EvictionPolicy, error) {
+	switch policyType {
+	case "", EvictionLRU:
+		return NewLRUPolicy(), nil
+	case EvictionLFU:
+		return NewLFUPolicy(), nil
+	case EvictionARC:
+		return NewARCPolicy(), nil
+	case EvictionWTinyLFU:
+		return NewWTinyLFUPolicy(), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %s", policyType)
+	}
+}
+
+// NewEvictionPolicyFactory validates policyType and returns a constructor of
+// independent policies of that type, one per cache shard.
+func NewEvictionPolicyFactory(policyType EvictionPolicyType) (func() EvictionPolicy, error) {
+	if _, err := NewEvictionPolicy(policyType); err != nil {
+		return nil, err
+	}
+
+	return func() EvictionPolicy {
+		policy, _ := NewEvictionPolicy(policyType)
+		return policy
+	}, nil
+}
@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"testing"
+	"time"
+)
+
+var policyTypes = []EvictionPolicyType{EvictionLRU, EvictionLFU, EvictionARC, EvictionWTinyLFU}
+
+func newPolicyCache(t testing.TB, policyType EvictionPolicyType, maxSize int) *HttpCache {
+	policy, err := NewEvictionPolicy(policyType)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A single shard, so that the policy sees every entry.
+	c := NewEmptyCacheWithOptions(1, 1, Options{Eviction: func() EvictionPolicy { return policy }, Shards: 1})
+	c.MaxSize = maxSize
+	t.Cleanup(func() { c.Cleanup() })
+
+	return c
+}
+
+func storeKey(c *HttpCache, key Key, size int) bool {
+	return c.setEntry(key, Entry{StatusCode: 200, Body: make([]byte, size), ExpiresAt: time.Now().Add(time.Hour)})
+}
+
+func TestNewEvictionPolicy(t *testing.T) {
+	for _, policyType := range append(policyTypes, "") {
+		if _, err := NewEvictionPolicy(policyType); err != nil {
+			t.Errorf("policy %q: unexpected error %v", policyType, err)
+		}
+	}
+
+	if _, err := NewEvictionPolicy("fifo"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestPoliciesKeepCacheWithinBounds(t *testing.T) {
+	for _, policyType := range policyTypes {
+		t.Run(string(policyType), func(t *testing.T) {
+			c := newPolicyCache(t, policyType, 100)
+
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("key-%d", i%37)
+				if _, found := c.getEntry(key); !found {
+					storeKey(c, key, 1+i%20)
+				}
+
+				if c.CurrentSize() > c.MaxSize {
+					t.Fatalf("step %d: cache size %d exceeds max size %d", i, c.CurrentSize(), c.MaxSize)
+				}
+			}
+
+			total := 0
+			for _, s := range c.shards {
+				for _, entry := range s.entries {
+					total += len(entry.Body)
+				}
+			}
+			if total != c.CurrentSize() {
+				t.Errorf("size accounting drifted: entries hold %d bytes, CurrentSize is %d", total, c.CurrentSize())
+			}
+		})
+	}
+}
+
+func TestPoliciesKeepReplacedEntriesNextInLine(t *testing.T) {
+	// The key each policy would evict first once the cache is full, a larger
+	// body replacing it evicts another entry instead.
+	nextInLine := map[EvictionPolicyType]Key{
+		EvictionLRU:      "key-0",
+		EvictionLFU:      "key-0",
+		EvictionARC:      "key-0",
+		EvictionWTinyLFU: "key-8", // the window is moved to the main space first
+	}
+
+	for _, policyType := range policyTypes {
+		t.Run(string(policyType), func(t *testing.T) {
+			c := newPolicyCache(t, policyType, 10)
+
+			for i := 0; i < 10; i++ {
+				storeKey(c, fmt.Sprintf("key-%d", i), 1)
+			}
+
+			key := nextInLine[policyType]
+			if !storeKey(c, key, 2) {
+				t.Fatal("expected the replacement to be stored")
+			}
+			if entry, found := c.getEntry(key); !found || len(entry.Body) != 2 {
+				t.Errorf("expected the replacement to be held, got %d bytes, found %v", len(entry.Body), found)
+			}
+			if stats := c.Stats(); stats.Rejections != 0 || stats.Evictions != 1 {
+				t.Errorf("expected a single eviction and no rejection, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestLFUPolicyEvictsLeastFrequentlyUsed(t *testing.T) {
+	p := NewLFUPolicy()
+	p.Add("a", 1)
+	p.Add("b", 1)
+	p.Add("c", 1)
+	p.Touch("a")
+	p.Touch("a")
+	p.Touch("c")
+
+	expected := []Key{"b", "c", "a"}
+	for _, want := range expected {
+		if got, _ := p.Evict("", 0); got != want {
+			t.Errorf("expected %s to be evicted, got %s", want, got)
+		}
+	}
+
+	if _, found := p.Evict("", 0); found {
+		t.Error("expected nothing left to evict")
+	}
+}
+
+func TestARCGhostHitsMoveTarget(t *testing.T) {
+	c := newPolicyCache(t, EvictionARC, 10)
+	arc := c.shards[0].policy.(*arcPolicy)
+
+	for i := 0; i < 20; i++ {
+		storeKey(c, fmt.Sprintf("key-%d", i), 1)
+	}
+	if arc.b1.len() == 0 {
+		t.Fatal("expected evicted keys to be remembered as ghosts")
+	}
+
+	// key-0 was evicted from t1, storing it again is a b1 ghost hit.
+	storeKey(c, "key-0", 1)
+	if arc.target == 0 {
+		t.Error("expected a b1 ghost hit to grow the target size of t1")
+	}
+}
+
+func TestScanResistantPoliciesKeepHotSet(t *testing.T) {
+	for _, policyType := range []EvictionPolicyType{EvictionARC, EvictionWTinyLFU} {
+		t.Run(string(policyType), func(t *testing.T) {
+			c := newPolicyCache(t, policyType, 100)
+
+			for round := 0; round < 10; round++ {
+				for i := 0; i < 50; i++ {
+					key := fmt.Sprintf("hot-%d", i)
+					if _, found := c.getEntry(key); !found {
+						storeKey(c, key, 1)
+					}
+					applyHits(c)
+				}
+			}
+
+			for i := 0; i < 1000; i++ {
+				storeKey(c, fmt.Sprintf("scan-%d", i), 1)
+			}
+
+			kept := 0
+			for i := 0; i < 50; i++ {
+				if _, found := c.getEntry(fmt.Sprintf("hot-%d", i)); found {
+					kept++
+				}
+			}
+
+			if kept < 25 {
+				t.Errorf("expected most of the hot set to survive the scan, kept %d/50", kept)
+			}
+		})
+	}
+}
+
+// trace.txt.gz is a synthetic access log: 100k requests over 4000 items whose
+// popularity follows a Zipf distribution, with a 5000 pages crawler scan every
+// 20k requests.
+func loadTrace(b *testing.B) []Key {
+	f, err := os.Open("testdata/trace.txt.gz")
+	if err != nil {
+		b.Fatalf("failed to open trace: %v", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		b.Fatalf("failed to read trace: %v", err)
+	}
+
+	var keys []Key
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		keys = append(keys, scanner.Text())
+	}
+
+	return keys
+}
+
+func traceEntrySize(key Key) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return 1024 << (h.Sum32() % 6) // 1 KiB to 32 KiB
+}
+
+func BenchmarkEvictionPolicyHitRatio(b *testing.B) {
+	trace := loadTrace(b)
+
+	for _, policyType := range policyTypes {
+		b.Run(string(policyType), func(b *testing.B) {
+			var hits, requests int
+
+			for n := 0; n < b.N; n++ {
+				c := newPolicyCache(b, policyType, MiBToBytes(8))
+				hits, requests = 0, 0
+
+				for _, key := range trace {
+					requests++
+					if _, found := c.getEntry(key); found {
+						hits++
+						continue
+					}
+					storeKey(c, key, traceEntrySize(key))
+				}
+			}
+
+			b.ReportMetric(100*float64(hits)/float64(requests), "hit%")
+		})
+	}
+}
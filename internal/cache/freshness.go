@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// heuristicFraction of the time since Last-Modified is used as freshness
+	// lifetime when the response does not carry an explicit one.
+	heuristicFraction = 0.1
+	HeuristicMaxTTL   = 24 * time.Hour
+)
+
+// HeaderTTL returns the explicit freshness lifetime of a response, Expires is
+// taken relatively to the response Date (RFC 9111 §4.2.1).
+func HeaderTTL(h http.Header) (bool, time.Duration) {
+	present, duration := ParseCacheControl(h.Get("Cache-Control")).TTL()
+	if present {
+		return true, duration
+	}
+
+	expiresHeader := h.Get("Expires")
+	if expiresHeader == "" {
+		return false, 0
+	}
+
+	expiresTime, err := http.ParseTime(expiresHeader)
+	if err != nil {
+		// Invalid dates, such as "0", are in the past.
+		return true, 0
+	}
+
+	return true, max(expiresTime.Sub(responseDate(h, time.Now())), 0)
+}
+
+// HeuristicTTL returns a freshness lifetime for responses without explicit
+// one, a fraction of the time elapsed since Last-Modified capped to
+// HeuristicMaxTTL (RFC 9111 §4.2.2).
+func HeuristicTTL(h http.Header) (bool, time.Duration) {
+	lastModified, ok := parseHTTPDate(h.Get("Last-Modified"))
+	if !ok {
+		return false, 0
+	}
+
+	elapsed := responseDate(h, time.Now()).Sub(lastModified)
+	if elapsed <= 0 {
+		return false, 0
+	}
+
+	return true, min(time.Duration(float64(elapsed)*heuristicFraction), HeuristicMaxTTL)
+}
+
+// InitialAge is the age of a response when received at now, from its Date and
+// Age headers (RFC 9111 §4.2.3).
+func InitialAge(h http.Header, now time.Time) time.Duration {
+	apparentAge := max(now.Sub(responseDate(h, now)), 0)
+
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(min(seconds, maxDeltaSeconds)) * time.Second
+	}
+
+	return max(apparentAge, ageValue)
+}
+
+func responseDate(h http.Header, fallback time.Time) time.Time {
+	if date, ok := parseHTTPDate(h.Get("Date")); ok {
+		return date
+	}
+
+	return fallback
+}
+
+// Age is how old the entry is, from its initial age and the time elapsed
+// since it was stored or last revalidated.
+func (entry *Entry) Age() time.Duration {
+	return entry.InitialAge + time.Since(entry.StoredAt)
+}
+
+func writeAgeHeader(w http.ResponseWriter, entry *Entry) {
+	if entry.StoredAt.IsZero() {
+		return
+	}
+
+	w.Header().Set("Age", strconv.FormatInt(int64(entry.Age()/time.Second), 10))
+}
+
+// Satisfies tells if the entry can answer a request carrying the reqCC
+// directives without contacting the backend (RFC 9111 §4.2 and §5.2.1). Stale
+// entries only qualify through the request max-stale directive.
+func (entry *Entry) Satisfies(reqCC *HeaderCacheControl) bool {
+	resCC := ParseCacheControl(entry.Header.Get("Cache-Control"))
+	fresh := !entry.IsExpired()
+
+	if reqCC.MinFresh >= 0 && time.Until(entry.ExpiresAt) < time.Duration(reqCC.MinFresh)*time.Second {
+		return false
+	}
+
+	// Reloads of immutable responses are answered from cache while fresh
+	// (RFC 8246).
+	reload := reqCC.NoCache || (reqCC.MaxAge >= 0 && entry.Age() > time.Duration(reqCC.MaxAge)*time.Second)
+	if reload && !(fresh && resCC.Immutable) {
+		return false
+	}
+
+	if fresh {
+		return true
+	}
+
+	if resCC.mustRevalidateWhenStale() {
+		return false
+	}
+
+	return reqCC.MaxStale == AnyStale || (reqCC.MaxStale >= 0 && time.Since(entry.ExpiresAt) <= time.Duration(reqCC.MaxStale)*time.Second)
+}
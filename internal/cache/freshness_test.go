@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderTTLExpiresRelativeToDate(t *testing.T) {
+	date := time.Now().Add(-time.Hour).UTC()
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		present  bool
+		expected time.Duration
+	}{
+		{"expires after date", http.Header{
+			"Date":    []string{date.Format(http.TimeFormat)},
+			"Expires": []string{date.Add(2 * time.Minute).Format(http.TimeFormat)},
+		}, true, 2 * time.Minute},
+		{"expires before date", http.Header{
+			"Date":    []string{date.Format(http.TimeFormat)},
+			"Expires": []string{date.Add(-time.Minute).Format(http.TimeFormat)},
+		}, true, 0},
+		{"invalid expires", http.Header{"Expires": []string{"0"}}, true, 0},
+		{"max-age wins over expires", http.Header{
+			"Cache-Control": []string{"max-age=30"},
+			"Expires":       []string{date.Add(time.Hour).Format(http.TimeFormat)},
+		}, true, 30 * time.Second},
+		{"nothing", http.Header{}, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			present, ttl := HeaderTTL(tt.header)
+			if present != tt.present || ttl != tt.expected {
+				t.Errorf("HeaderTTL() = %v, %v, expected %v, %v", present, ttl, tt.present, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHeuristicTTL(t *testing.T) {
+	date := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name         string
+		lastModified time.Time
+		present      bool
+		expected     time.Duration
+	}{
+		{"fraction of the last modified age", date.Add(-10 * time.Hour), true, time.Hour},
+		{"capped", date.Add(-30 * 24 * time.Hour), true, HeuristicMaxTTL},
+		{"modified in the future", date.Add(time.Hour), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{
+				"Date":          []string{date.Format(http.TimeFormat)},
+				"Last-Modified": []string{tt.lastModified.Format(http.TimeFormat)},
+			}
+
+			present, ttl := HeuristicTTL(h)
+			if present != tt.present || ttl != tt.expected {
+				t.Errorf("HeuristicTTL() = %v, %v, expected %v, %v", present, ttl, tt.present, tt.expected)
+			}
+		})
+	}
+
+	if present, _ := HeuristicTTL(http.Header{}); present {
+		t.Error("expected no heuristic without Last-Modified")
+	}
+}
+
+func TestInitialAge(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{"no headers", http.Header{}, 0},
+		{"apparent age from date", http.Header{"Date": []string{now.Add(-10 * time.Second).Format(http.TimeFormat)}}, 10 * time.Second},
+		{"upstream age", http.Header{"Date": []string{now.Format(http.TimeFormat)}, "Age": []string{"100"}}, 100 * time.Second},
+		{"greatest of both", http.Header{"Date": []string{now.Add(-time.Minute).Format(http.TimeFormat)}, "Age": []string{"5"}}, time.Minute},
+		{"date in the future", http.Header{"Date": []string{now.Add(time.Minute).Format(http.TimeFormat)}}, 0},
+		{"invalid age", http.Header{"Age": []string{"abc"}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InitialAge(tt.header, now); got != tt.expected {
+				t.Errorf("InitialAge() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteResponseSetsAge(t *testing.T) {
+	entry := Entry{
+		StatusCode: 200,
+		Body:       []byte("aged"),
+		Header:     http.Header{"Age": []string{"1"}, "Etag": []string{`"v1"`}},
+		StoredAt:   time.Now().Add(-5 * time.Second),
+		InitialAge: 30 * time.Second,
+	}
+
+	rec := httptest.NewRecorder()
+	if err := entry.WriteResponse(rec, httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+
+	if got := rec.Header().Values("Age"); len(got) != 1 || got[0] != "35" {
+		t.Errorf("expected Age: 35, got %v", got)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	rec = httptest.NewRecorder()
+	if err := entry.WriteResponse(rec, req); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+
+	if got := rec.Header().Values("Age"); rec.Code != http.StatusNotModified || len(got) != 1 || got[0] != "35" {
+		t.Errorf("expected 304 with Age: 35, got %d %v", rec.Code, got)
+	}
+}
+
+func TestRevalidatedResetsAge(t *testing.T) {
+	entry := Entry{
+		StatusCode: 200,
+		Header:     http.Header{"Age": []string{"500"}, "Date": []string{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}},
+		StoredAt:   time.Now().Add(-time.Hour),
+		InitialAge: 500 * time.Second,
+	}
+
+	refreshed := entry.Revalidated(http.Header{"Date": []string{time.Now().UTC().Format(http.TimeFormat)}})
+
+	if refreshed.Header.Get("Age") != "" {
+		t.Errorf("expected stale Age header to be dropped, got %v", refreshed.Header)
+	}
+
+	if refreshed.Age() > 2*time.Second {
+		t.Errorf("expected revalidated entry to be young, got %v", refreshed.Age())
+	}
+}
@@ -1,93 +1,529 @@
 package cache
 
 import (
+	"hash/maphash"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// hitBufferSize bounds how many cache hits can be recorded between two writes
+// or sweeps. A full buffer is drained by the next hit finding the shard
+// unlocked, the hits arriving while it is locked are dropped so reads never
+// block on recency bookkeeping.
+const hitBufferSize = 1024
+
 type HttpCache struct {
 	MaxSize      int // in bytes
 	MaxEntrySize int // in bytes
-	Entries      map[Key]Entry
-	CurrentSize  int // in bytes
+	shards       []*shard
+	seed         maphash.Seed
+	size         atomic.Int64 // in bytes, sum of the shard sizes
+	generation   atomic.Uint64
+	purgeMu      sync.Mutex
+	purges       []purgeRecord // recent purges, oldest first
+	keyBuilder   *KeyBuilder
+	stale        StalePolicy
+	disk         *DiskStore
+	compression  Encoding
+	shared       Store
 	stop         chan struct{}
-	sync.RWMutex
+	evictions    atomic.Int64
+	rejections   atomic.Int64
 }
 
-type Key = string
+type Options struct {
+	Eviction    func() EvictionPolicy // builds the policy of each shard, LRU by default
+	Shards      int                   // DefaultShards when not set
+	Key         *KeyBuilder
+	Stale       StalePolicy // used when responses do not carry stale directives
+	Disk        *DiskStore  // second tier receiving evicted and oversized entries
+	Compression Encoding    // content coding of stored bodies, identity by default
+	Shared      Store       // store shared with other instances, consulted on misses
+}
 
-func KeyFrom(request *http.Request) Key {
-	return request.URL.String()
+type Stats struct {
+	Entries     int
+	CurrentSize int
+	MaxSize     int
+	Evictions   int64
+	Rejections  int64
+	DiskEntries int
+	DiskSize    int
 }
 
 func NewCache(entries map[Key]Entry, maxSizeMiB int, maxEntrySizeMiB int) *HttpCache {
+	return NewCacheWithOptions(entries, maxSizeMiB, maxEntrySizeMiB, Options{})
+}
+
+func NewCacheWithOptions(entries map[Key]Entry, maxSizeMiB int, maxEntrySizeMiB int, opts Options) *HttpCache {
+	newPolicy := opts.Eviction
+	if newPolicy == nil {
+		newPolicy = NewLRUPolicy
+	}
+
+	shards := opts.Shards
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+
 	cache := &HttpCache{
-		Entries:      entries,
 		MaxSize:      MiBToBytes(maxSizeMiB),
 		MaxEntrySize: MiBToBytes(maxEntrySizeMiB),
+		shards:       make([]*shard, shards),
+		seed:         maphash.MakeSeed(),
+		keyBuilder:   opts.Key,
+		stale:        opts.Stale,
+		disk:         opts.Disk,
+		compression:  opts.Compression,
+		shared:       opts.Shared,
 		stop:         make(chan struct{}),
 	}
 
+	for i := range cache.shards {
+		cache.shards[i] = newShard(i, newPolicy())
+	}
+
+	for key, entry := range entries {
+		s := cache.shardFor(key)
+		s.entries[key] = entry
+		s.size += len(entry.Body)
+		s.policy.Add(key, len(entry.Body))
+		s.tagLocked(key, entry)
+		cache.size.Add(int64(len(entry.Body)))
+	}
+
+	if cache.disk != nil {
+		cache.disk.each(func(key, primary Key, meta Entry) {
+			if _, exists := entries[key]; exists {
+				cache.disk.remove(key)
+				return
+			}
+			cache.indexDiskEntry(key, primary, meta)
+		})
+	}
+
 	go cache.autoCleanup(3 * time.Minute)
 
 	return cache
 }
 
 func NewEmptyCache(maxSizeMiB, maxEntrySizeMiB int) *HttpCache {
+	return NewEmptyCacheWithOptions(maxSizeMiB, maxEntrySizeMiB, Options{})
+}
+
+func NewEmptyCacheWithOptions(maxSizeMiB, maxEntrySizeMiB int, opts Options) *HttpCache {
 	emptyEntries := make(map[Key]Entry)
-	return NewCache(emptyEntries, maxSizeMiB, maxEntrySizeMiB)
+	return NewCacheWithOptions(emptyEntries, maxSizeMiB, maxEntrySizeMiB, opts)
 }
 
 func (c *HttpCache) Cleanup() {
 	close(c.stop)
+
+	if c.disk != nil {
+		_ = c.disk.Close()
+	}
+
+	c.closeShared()
 }
 
+// Get returns the fresh entry matching r.
 func (c *HttpCache) Get(r *http.Request) (Entry, bool) {
-	key := KeyFrom(r)
+	if entry, found := c.getEntry(c.lookupKey(r)); found {
+		return entry, true
+	}
+
+	return c.getShared(r)
+}
+
+// Lookup returns the entry matching r, stale entries are returned as long as
+// they can be revalidated or are within their stale windows.
+func (c *HttpCache) Lookup(r *http.Request) (Entry, bool) {
+	if entry, found := c.lookupEntry(c.lookupKey(r)); found {
+		return entry, true
+	}
+
+	return c.getShared(r)
+}
 
-	c.RLock()
-	entry, exists := c.Entries[key]
-	c.RUnlock()
+func (c *HttpCache) KeyFor(r *http.Request) Key {
+	if c.keyBuilder != nil {
+		return c.keyBuilder.Key(r)
+	}
+
+	return KeyFrom(r)
+}
+
+func (c *HttpCache) lookupKey(r *http.Request) Key {
+	primary := c.KeyFor(r)
+	s := c.shardFor(primary)
+
+	s.RLock()
+	index, exists := s.variants[primary]
+	s.RUnlock()
 
 	if !exists {
+		return primary
+	}
+
+	return variantKey(primary, index.headers, selectingHeaders(r, index.headers))
+}
+
+func (c *HttpCache) getEntry(key Key) (Entry, bool) {
+	entry, found := c.lookupEntry(key)
+	if !found || entry.IsExpired() {
 		return Entry{}, false
 	}
 
-	if entry.IsExpired() {
-		c.delete(key)
+	return entry, true
+}
+
+func (c *HttpCache) lookupEntry(key Key) (Entry, bool) {
+	s := c.shardFor(key)
+
+	s.RLock()
+	entry, exists := s.entries[key]
+	s.RUnlock()
+
+	if !exists {
+		return c.loadFromDisk(s, key)
+	}
+
+	if !c.isUsable(&entry) {
+		c.delete(s, key)
 		return Entry{}, false
 	}
 
-	return entry.Clone(), true
+	s.recordHit(key)
+
+	return entry.Shared(), true
+}
+
+// loadFromDisk reads an entry from the disk tier and promotes it to memory
+// when it fits there.
+func (c *HttpCache) loadFromDisk(s *shard, key Key) (Entry, bool) {
+	if c.disk == nil {
+		return Entry{}, false
+	}
+
+	entry, found := c.disk.load(key)
+	if !found {
+		return Entry{}, false
+	}
+
+	if !c.isUsable(&entry) {
+		c.delete(s, key)
+		return Entry{}, false
+	}
+
+	if c.fitsInMemory(len(entry.Body)) {
+		promoted := false
+		c.update(s, func() {
+			// The entry may have been replaced or deleted while it was read.
+			if meta, exists := c.disk.peek(key); exists && meta.StoredAt.Equal(entry.StoredAt) {
+				s.applyHits()
+				if promoted = c.storeInMemoryLocked(s, key, entry); promoted {
+					c.disk.remove(key)
+				}
+			}
+		})
+		if promoted {
+			c.reclaim(s)
+		}
+	}
+
+	return entry.Shared(), true
+}
+
+// CaptureLimit is the size of the largest body the cache may store, on its
+// disk tier when there is one.
+func (c *HttpCache) CaptureLimit() int {
+	if c.disk != nil {
+		return max(c.MaxEntrySize, c.disk.maxSize)
+	}
+
+	return c.MaxEntrySize
+}
+
+func (c *HttpCache) fitsInMemory(size int) bool {
+	return size <= c.MaxEntrySize && size <= c.MaxSize
 }
 
 func (c *HttpCache) Set(r *http.Request, resp *CachableResponse, expiresAt time.Time) {
-	key, entry := NewEntry(r, resp, expiresAt)
-	entrySize := len(entry.Body)
+	c.SetAt(c.Generation(), r, resp, expiresAt)
+}
+
+// SetAt stores the response unless the cache was purged since generation was
+// read, the response then possibly predates the purge.
+func (c *HttpCache) SetAt(generation uint64, r *http.Request, resp *CachableResponse, expiresAt time.Time) bool {
+	varyHeaders, varyAll := parseVary(resp.Header())
+	if varyAll {
+		return false
+	}
+
+	_, entry := NewEntry(r, resp, expiresAt)
+	entry = entry.compressedWith(c.compression)
+	stored := c.setVariant(generation, c.KeyFor(r), varyHeaders, entry)
+	if stored {
+		c.setShared(r, resp, varyHeaders, entry)
+	}
+
+	return stored
+}
+
+func (c *HttpCache) setEntry(key Key, entry Entry) bool {
+	return c.setVariant(c.Generation(), key, nil, entry)
+}
+
+func (c *HttpCache) setVariant(generation uint64, primary Key, varyHeaders []string, entry Entry) bool {
+	if c.disk == nil && !c.fitsInMemory(len(entry.Body)) {
+		return false
+	}
 
-	if !c.CanStore(entry.Body) {
+	s := c.shardFor(primary)
+	stored := false
+
+	c.update(s, func() {
+		if c.purgedSince(generation, primary, &entry) {
+			return
+		}
+
+		s.applyHits()
+
+		// A response varying on other headers than the cached ones makes every
+		// stored variant unreachable, and so does a first varying response the
+		// entry stored without Vary.
+		if index, exists := s.variants[primary]; exists && !sameHeaders(index.headers, varyHeaders) {
+			c.deleteVariantsLocked(s, primary)
+		} else if !exists && len(varyHeaders) > 0 {
+			c.deleteLocked(s, primary)
+		}
+
+		key := variantKey(primary, varyHeaders, entry.Vary)
+		s.indexVariantLocked(primary, varyHeaders, key)
+
+		stored = c.storeLocked(s, key, entry)
+	})
+
+	if stored {
+		c.reclaim(s)
+	}
+
+	return stored
+}
+
+// indexDiskEntry registers an entry found on disk at startup.
+func (c *HttpCache) indexDiskEntry(key, primary Key, meta Entry) {
+	s := c.shardFor(key)
+	varyHeaders := varyHeaderNames(meta.Vary)
+
+	if index, exists := s.variants[primary]; len(varyHeaders) > 0 && exists && !sameHeaders(index.headers, varyHeaders) {
+		c.disk.remove(key)
 		return
 	}
 
-	c.Lock()
-	defer c.Unlock()
+	s.indexVariantLocked(primary, varyHeaders, key)
+	s.tagLocked(key, meta)
+}
 
-	if old, exists := c.Entries[key]; exists {
-		c.CurrentSize -= len(old.Body)
+// storeLocked stores the entry in memory, or on disk when memory cannot hold
+// it.
+func (c *HttpCache) storeLocked(s *shard, key Key, entry Entry) bool {
+	if c.disk != nil {
+		if meta, onDisk := c.disk.remove(key); onDisk {
+			s.untagLocked(key, meta)
+		}
 	}
 
-	if c.CurrentSize+entrySize > c.MaxSize {
+	if c.fitsInMemory(len(entry.Body)) && c.storeInMemoryLocked(s, key, entry) {
+		return true
+	}
+
+	c.dropFromMemoryLocked(s, key)
+
+	return c.demoteLocked(s, key, entry)
+}
+
+// storeInMemoryLocked makes room for the entry by moving evicted entries of
+// the shard to disk, it fails when the eviction policy refuses the entry.
+// Other shards are left to reclaim.
+func (c *HttpCache) storeInMemoryLocked(s *shard, key Key, entry Entry) bool {
+	entrySize := len(entry.Body)
+
+	// Replaced entries keep their eviction history.
+	old, replaced := s.entries[key]
+	if replaced {
+		delete(s.entries, key)
+		s.size -= len(old.Body)
+		c.size.Add(-int64(len(old.Body)))
+		s.untagLocked(key, old)
+	}
+
+	for c.size.Load()+int64(entrySize) > int64(c.MaxSize) && len(s.entries) > 0 {
+		victim, found := s.policy.Evict(key, entrySize)
+		if victim == key && replaced {
+			// The replaced entry was next in line, it already left memory
+			// and is added back below.
+			replaced = false
+			continue
+		}
+		if victim == key {
+			s.policy.Remove(key)
+			c.rejections.Add(1)
+			return false
+		}
+		if !found {
+			break
+		}
+
+		c.evictLocked(s, victim)
+		c.evictions.Add(1)
+	}
+
+	s.entries[key] = entry
+	s.size += entrySize
+	c.size.Add(int64(entrySize))
+	s.policy.Add(key, entrySize)
+	s.tagLocked(key, entry)
+
+	return true
+}
+
+// demoteLocked moves an entry to the disk tier, entries the disk evicts in
+// return are forgotten once the shard is unlocked.
+func (c *HttpCache) demoteLocked(s *shard, key Key, entry Entry) bool {
+	if c.disk == nil {
+		s.forgetVariantLocked(key)
+		return false
+	}
+
+	evicted, stored := c.disk.stage(key, s.primaryLocked(key), entry)
+	for victim, meta := range evicted {
+		if s.orphans == nil {
+			s.orphans = make(map[Key]Entry)
+		}
+		s.orphans[victim] = meta
+	}
+
+	if !stored {
+		s.forgetVariantLocked(key)
+		return false
+	}
+
+	s.tagLocked(key, entry)
+
+	return true
+}
+
+// evictLocked moves a victim returned by the eviction policy out of memory.
+// The policy already forgot it, and may remember it as a ghost.
+func (c *HttpCache) evictLocked(s *shard, victim Key) {
+	entry, exists := c.unlinkLocked(s, victim)
+	if !exists {
+		s.forgetVariantLocked(victim)
 		return
 	}
 
-	c.Entries[key] = entry
-	c.CurrentSize += entrySize
+	c.demoteLocked(s, victim, entry)
+}
+
+// Refresh replaces the headers and expiration of the stored entry matching r
+// after a successful revalidation, the stored body is kept. The shared store
+// gets the refreshed entry too.
+func (c *HttpCache) Refresh(r *http.Request, revalidated Entry) bool {
+	key := c.lookupKey(r)
+	s := c.shardFor(key)
+
+	// The shared copy may be the one revalidated, it is refreshed either way.
+	refreshed, exists := revalidated, false
+	c.update(s, func() {
+		if entry, found := s.entries[key]; found {
+			s.untagLocked(key, entry)
+			refreshed, exists = entry.refreshedBy(revalidated), true
+			s.tagLocked(key, refreshed)
+			s.entries[key] = refreshed
+		}
+	})
+
+	if !exists && c.disk != nil {
+		if entry, found := c.refreshOnDisk(s, key, revalidated); found {
+			refreshed, exists = entry, true
+		}
+	}
+
+	c.setShared(r, nil, varyHeaderNames(refreshed.Vary), refreshed)
+
+	return exists
+}
+
+func (c *HttpCache) refreshOnDisk(s *shard, key Key, revalidated Entry) (Entry, bool) {
+	entry, found := c.disk.load(key)
+	if !found {
+		return Entry{}, false
+	}
+
+	refreshed := entry.refreshedBy(revalidated)
+	stored := false
+	c.update(s, func() {
+		meta, exists := c.disk.peek(key)
+		if !exists || !meta.StoredAt.Equal(entry.StoredAt) {
+			return
+		}
+
+		s.untagLocked(key, meta)
+		stored = c.demoteLocked(s, key, refreshed)
+	})
+
+	return refreshed, stored
+}
+
+func (entry *Entry) refreshedBy(revalidated Entry) Entry {
+	refreshed := *entry
+	refreshed.Header = cloneHeader(revalidated.Header)
+	refreshed.ExpiresAt = revalidated.ExpiresAt
+	refreshed.StoredAt = revalidated.StoredAt
+	refreshed.InitialAge = revalidated.InitialAge
+
+	return refreshed
+}
+
+func (c *HttpCache) StalePolicy(entry Entry) StalePolicy {
+	return entry.StalePolicy(c.stale)
+}
+
+func (c *HttpCache) isUsable(entry *Entry) bool {
+	return !entry.IsExpired() || entry.IsRevalidatable() || entry.IsStaleWithin(entry.StalePolicy(c.stale).window())
 }
 
 func (c *HttpCache) Invalidate(r *http.Request) {
-	key := KeyFrom(r)
-	c.delete(key)
+	primary := c.KeyFor(r)
+	s := c.shardFor(primary)
+
+	c.update(s, func() {
+		c.deleteVariantsLocked(s, primary)
+	})
+
+	if c.shared != nil {
+		c.shared.Delete(r)
+	}
+}
+
+func (c *HttpCache) deleteVariantsLocked(s *shard, primary Key) int {
+	deleted := 0
+	if index, exists := s.variants[primary]; exists {
+		for key := range index.keys {
+			if c.deleteLocked(s, key) {
+				deleted++
+			}
+		}
+	}
+
+	if c.deleteLocked(s, primary) {
+		deleted++
+	}
+
+	return deleted
 }
 
 func (c *HttpCache) ServeIfPresent(w http.ResponseWriter, r *http.Request) (bool, error) {
@@ -99,40 +535,127 @@ func (c *HttpCache) ServeIfPresent(w http.ResponseWriter, r *http.Request) (bool
 	return true, entry.WriteResponse(w, r)
 }
 
-func (c *HttpCache) delete(key string) {
-	c.Lock()
-	entry, exists := c.Entries[key]
+func (c *HttpCache) Stats() Stats {
+	stats := Stats{
+		CurrentSize: c.CurrentSize(),
+		MaxSize:     c.MaxSize,
+		Evictions:   c.evictions.Load(),
+		Rejections:  c.rejections.Load(),
+	}
+
+	for _, s := range c.shards {
+		s.RLock()
+		stats.Entries += len(s.entries)
+		s.RUnlock()
+	}
+
+	if c.disk != nil {
+		stats.DiskEntries, stats.DiskSize = c.disk.stats()
+	}
+
+	return stats
+}
+
+// CurrentSize is the size of the entries held in memory, in bytes.
+func (c *HttpCache) CurrentSize() int {
+	return int(c.size.Load())
+}
+
+func (c *HttpCache) delete(s *shard, key Key) {
+	c.update(s, func() {
+		c.deleteLocked(s, key)
+	})
+}
+
+// deleteLocked removes key from both tiers, and reports whether it was
+// stored.
+func (c *HttpCache) deleteLocked(s *shard, key Key) bool {
+	if !c.removeLocked(s, key) {
+		return false
+	}
+
+	s.forgetVariantLocked(key)
+
+	return true
+}
+
+// removeLocked removes key from both tiers but keeps its variant index.
+func (c *HttpCache) removeLocked(s *shard, key Key) bool {
+	_, removed := c.dropFromMemoryLocked(s, key)
+
+	if c.disk != nil {
+		if meta, onDisk := c.disk.remove(key); onDisk {
+			s.untagLocked(key, meta)
+			removed = true
+		}
+	}
+
+	return removed
+}
+
+func (c *HttpCache) dropFromMemoryLocked(s *shard, key Key) (Entry, bool) {
+	entry, exists := c.unlinkLocked(s, key)
+	if exists {
+		s.policy.Remove(key)
+	}
+
+	return entry, exists
+}
+
+// unlinkLocked removes key from memory without telling the eviction policy.
+func (c *HttpCache) unlinkLocked(s *shard, key Key) (Entry, bool) {
+	entry, exists := s.entries[key]
 	if !exists {
-		c.Unlock()
-		return
+		return Entry{}, false
+	}
+
+	delete(s.entries, key)
+	s.untagLocked(key, entry)
+	s.size -= len(entry.Body)
+	c.size.Add(-int64(len(entry.Body)))
+
+	return entry, true
+}
+
+// peekLocked returns the entry stored under key in either tier, disk entries
+// without their body.
+func (c *HttpCache) peekLocked(s *shard, key Key) (Entry, bool) {
+	if entry, exists := s.entries[key]; exists {
+		return entry, true
+	}
+
+	if c.disk != nil {
+		return c.disk.peek(key)
 	}
 
-	delete(c.Entries, key)
-	c.CurrentSize -= len(entry.Body)
-	if c.CurrentSize < 0 {
-		c.CurrentSize = 0
+	return Entry{}, false
+}
+
+func (c *HttpCache) onDisk(key Key) bool {
+	if c.disk == nil {
+		return false
 	}
-	c.Unlock()
+
+	_, exists := c.disk.peek(key)
+	return exists
 }
 
+// autoCleanup sweeps a single shard per tick, so that every shard is swept
+// once per interval, and the disk tier once per round.
 func (c *HttpCache) autoCleanup(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(max(interval/time.Duration(len(c.shards)), time.Millisecond))
 	defer ticker.Stop()
 
+	next := 0
 	for {
 		select {
 		case <-ticker.C:
-			c.Lock()
-			for key, entry := range c.Entries {
-				if entry.IsExpired() {
-					delete(c.Entries, key)
-					c.CurrentSize -= len(entry.Body)
-				}
-			}
-			if c.CurrentSize < 0 {
-				c.CurrentSize = 0
+			c.sweep(c.shards[next])
+
+			next = (next + 1) % len(c.shards)
+			if next == 0 {
+				c.sweepDisk()
 			}
-			c.Unlock()
 
 		case <-c.stop:
 			return
@@ -140,10 +663,16 @@ func (c *HttpCache) autoCleanup(interval time.Duration) {
 	}
 }
 
+func (c *HttpCache) eachOnDisk(fn func(key Key, meta Entry)) {
+	if c.disk == nil {
+		return
+	}
+
+	c.disk.each(func(key, _ Key, meta Entry) { fn(key, meta) })
+}
+
 func (c *HttpCache) HasFreeSpace(size int) bool {
-	c.RLock()
-	defer c.RUnlock()
-	return c.CurrentSize+size <= c.MaxSize
+	return c.CurrentSize()+size <= c.MaxSize
 }
 
 func (c *HttpCache) CanStore(entry []byte) bool {
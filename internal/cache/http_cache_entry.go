@@ -1,37 +1,64 @@
 package cache
 
 import (
+	"bytes"
 	"net/http"
 	"time"
 )
 
+// Entry is immutable once stored: its body is shared by the cache and every
+// hit, only its headers are copied when it is handed out.
 type Entry struct {
+	Path       string // request path, for prefix purges
 	StatusCode int
 	Body       []byte
 	Header     http.Header
 	ExpiresAt  time.Time
+	StoredAt   time.Time
+	InitialAge time.Duration // age of the response when it was stored
+	Vary       http.Header   // selecting request header values of this variant
+	Encoding   Encoding      // content coding the cache applied to Body
 }
 
 func NewEntry(request *http.Request, resp *CachableResponse, expiresAt time.Time) (Key, Entry) {
+	varyHeaders, _ := parseVary(resp.Header())
+	now := time.Now()
 
 	return KeyFrom(request), Entry{
+		Path:       request.URL.Path,
 		StatusCode: resp.StatusCode,
-		Body:       resp.Body.Bytes(),
-		Header:     ensureCacheHitHeader(cloneHeader(resp.Header())),
+		Body:       bytes.Clone(resp.Body.Bytes()),
+		Header:     ensureCacheHitHeader(storableHeader(resp.Header())),
 		ExpiresAt:  expiresAt,
+		StoredAt:   now,
+		InitialAge: InitialAge(resp.Header(), now),
+		Vary:       selectingHeaders(request, varyHeaders),
 	}
 }
 
-func (entry *Entry) Clone() Entry {
-	bodyCopy := make([]byte, len(entry.Body))
-	copy(bodyCopy, entry.Body)
+// Shared returns a copy of the entry owning its headers, sharing the body.
+func (entry *Entry) Shared() Entry {
+	shared := *entry
+	shared.Header = cloneHeader(entry.Header)
+	shared.Vary = cloneHeader(entry.Vary)
 
-	return Entry{
-		StatusCode: entry.StatusCode,
-		Body:       bodyCopy,
-		Header:     cloneHeader(entry.Header),
-		ExpiresAt:  entry.ExpiresAt,
+	return shared
+}
+
+// storableHeader copies the response header without the fields listed by the
+// private="..." and no-cache="..." directives.
+func storableHeader(h http.Header) http.Header {
+	stored := cloneHeader(h)
+
+	cc := ParseCacheControl(h.Get("Cache-Control"))
+	for _, name := range cc.PrivateFields {
+		stored.Del(name)
+	}
+	for _, name := range cc.NoCacheFields {
+		stored.Del(name)
 	}
+
+	return stored
 }
 
 func ensureCacheHitHeader(h http.Header) http.Header {
@@ -40,18 +67,84 @@ func ensureCacheHitHeader(h http.Header) http.Header {
 }
 
 func (entry *Entry) WriteResponse(w http.ResponseWriter, r *http.Request) error {
+	switch entry.preconditionStatus(r) {
+	case http.StatusNotModified:
+		writeNotModified(w, entry)
+		return nil
+	case http.StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return nil
+	}
+
 	for key, values := range entry.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
 
+	writeAgeHeader(w, entry)
+
+	if entry.StatusCode == http.StatusOK {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	if entry.servesRange(r) {
+		return entry.writeRanges(w, r)
+	}
+
+	if entry.Encoding != EncodingIdentity {
+		return entry.writeEncodedBody(w, r)
+	}
+
 	w.WriteHeader(entry.StatusCode)
 	_, err := w.Write(entry.Body)
 
 	return err
 }
 
+// preconditionStatus only evaluates conditional headers against successful
+// responses, as required by RFC 9110 §13.2.1.
+func (entry *Entry) preconditionStatus(r *http.Request) int {
+	if entry.StatusCode < 200 || entry.StatusCode >= 300 {
+		return 0
+	}
+
+	return evaluatePreconditions(r, entry.Header)
+}
+
 func (entry *Entry) IsExpired() bool {
 	return time.Now().After(entry.ExpiresAt)
 }
+
+// IsRevalidatable tells if a stale entry can be refreshed with a conditional
+// request instead of being fetched again.
+func (entry *Entry) IsRevalidatable() bool {
+	return entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != ""
+}
+
+// Revalidated returns a copy of the entry updated with the headers of a 304
+// response, as described in RFC 9111 §4.3.4.
+func (entry *Entry) Revalidated(notModified http.Header) Entry {
+	refreshed := entry.Shared()
+
+	// Date and Age of the stored response no longer describe it.
+	refreshed.Header.Del("Date")
+	refreshed.Header.Del("Age")
+
+	for key, values := range notModified {
+		switch key {
+		case "Content-Length", "X-Cache":
+			continue
+		}
+
+		copied := make([]string, len(values))
+		copy(copied, values)
+		refreshed.Header[key] = copied
+	}
+
+	refreshed.Header = storableHeader(refreshed.Header)
+	refreshed.StoredAt = time.Now()
+	refreshed.InitialAge = InitialAge(refreshed.Header, refreshed.StoredAt)
+
+	return refreshed
+}
@@ -97,3 +97,23 @@ func TestIsExpired(t *testing.T) {
 		t.Error("expected entry to not be expired")
 	}
 }
+
+func TestNewEntryDropsQualifiedPrivateAndNoCacheFields(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	resp := NewCachableResponse(httptest.NewRecorder())
+	resp.Header().Set("Cache-Control", `private="Set-Cookie", no-cache="X-Debug", max-age=60`)
+	resp.Header().Set("Set-Cookie", "session=secret")
+	resp.Header().Set("X-Debug", "1")
+	resp.Header().Set("Content-Type", "text/plain")
+	resp.WriteHeader(200)
+
+	_, entry := NewEntry(req, resp, time.Now().Add(time.Minute))
+
+	if entry.Header.Get("Set-Cookie") != "" || entry.Header.Get("X-Debug") != "" {
+		t.Errorf("expected listed fields to be dropped, got %v", entry.Header)
+	}
+
+	if entry.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("expected other fields to be kept, got %v", entry.Header)
+	}
+}
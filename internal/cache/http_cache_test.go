@@ -2,15 +2,16 @@ package cache
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"runtime"
 	"testing"
 	"time"
 )
 
-var rawTestDate = "2025-12-12T12:12:12Z"
-var testTime, _ = time.Parse(time.RFC3339, rawTestDate)
+var testTime = time.Now().Truncate(time.Second)
 var ttl = 1 * time.Minute
 
 var dummyEntry = Entry{
@@ -26,10 +27,39 @@ func newEmptyConfiguredCache(t *testing.T) *HttpCache {
 	return c
 }
 
+// storedEntry returns the entry held in memory under key.
+func storedEntry(c *HttpCache, key Key) (Entry, bool) {
+	s := c.shardFor(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, exists := s.entries[key]
+	return entry, exists
+}
+
+func applyHits(c *HttpCache) {
+	for _, s := range c.shards {
+		s.Lock()
+		s.applyHits()
+		s.Unlock()
+	}
+}
+
+func newSingleShardCache(t *testing.T) *HttpCache {
+	c := NewEmptyCacheWithOptions(1, 1, Options{Shards: 1})
+	t.Cleanup(func() { c.Cleanup() })
+	return c
+}
+
 func TestNewEmptyCache(t *testing.T) {
 	c := newEmptyConfiguredCache(t)
-	if c.Entries == nil {
-		t.Error("HttpCache entries map is not initialized")
+	if len(c.shards) != DefaultShards {
+		t.Errorf("expected %d shards, got %d", DefaultShards, len(c.shards))
+	}
+	for _, s := range c.shards {
+		if s.entries == nil {
+			t.Fatal("HttpCache entries map is not initialized")
+		}
 	}
 }
 
@@ -44,11 +74,11 @@ func TestCacheSetAndGet(t *testing.T) {
 	req := &http.Request{URL: &url.URL{Path: "testKey"}}
 	c.Set(req, resp, testTime.Add(ttl))
 
-	if len(c.Entries) != 1 {
+	if c.Stats().Entries != 1 {
 		t.Error("HttpCache entry was not set correctly")
 	}
 
-	entry := c.Entries["testKey"]
+	entry, _ := storedEntry(c, "testKey")
 	if entry.StatusCode != dummyEntry.StatusCode {
 		t.Error("HttpCache entry has incorrect StatusCode")
 	}
@@ -82,7 +112,7 @@ func TestHasFreeSpace(t *testing.T) {
 		t.Error("Expected cache to have free space for 1 MiB")
 	}
 
-	c.CurrentSize = c.MaxSize
+	c.size.Store(int64(c.MaxSize))
 	if c.HasFreeSpace(1) {
 		t.Error("Expected cache to be full and not have free space")
 	}
@@ -104,7 +134,7 @@ func TestCanStore(t *testing.T) {
 	}
 
 	// cache almost full
-	c.CurrentSize = c.MaxSize - 512 // bytes
+	c.size.Store(int64(c.MaxSize - 512)) // bytes
 	entrySmall := make([]byte, 1024)
 	if c.CanStore(entrySmall) {
 		t.Error("Expected CanStore to return false when cache does not have enough free space")
@@ -148,3 +178,263 @@ func TestServeIfPresent(t *testing.T) {
 		t.Error("ServeIfPresent wrote incorrect body")
 	}
 }
+
+func setTestEntry(c *HttpCache, path string, body []byte) *http.Request {
+	rec := httptest.NewRecorder()
+	resp := NewCachableResponse(rec)
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(body)
+
+	req := &http.Request{URL: &url.URL{Path: path}}
+	c.Set(req, resp, time.Now().Add(ttl))
+
+	return req
+}
+
+func TestSetEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newSingleShardCache(t)
+	c.MaxSize = 30
+
+	first := setTestEntry(c, "first", make([]byte, 10))
+	setTestEntry(c, "second", make([]byte, 10))
+	setTestEntry(c, "third", make([]byte, 10))
+
+	if _, found := c.Get(first); !found {
+		t.Fatal("expected first entry to be cached")
+	}
+
+	setTestEntry(c, "fourth", make([]byte, 10))
+
+	if _, exists := storedEntry(c, "second"); exists {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	for _, key := range []string{"first", "third", "fourth"} {
+		if _, exists := storedEntry(c, key); !exists {
+			t.Errorf("expected %s to still be cached", key)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Evictions != 1 {
+		t.Errorf("expected 1 eviction, got %d", stats.Evictions)
+	}
+	if stats.CurrentSize != 30 || stats.Entries != 3 {
+		t.Errorf("unexpected accounting: %+v", stats)
+	}
+}
+
+func TestSetEvictsAsManyEntriesAsNeeded(t *testing.T) {
+	c := newSingleShardCache(t)
+	c.MaxSize = 30
+
+	setTestEntry(c, "first", make([]byte, 10))
+	setTestEntry(c, "second", make([]byte, 10))
+	setTestEntry(c, "third", make([]byte, 5))
+	setTestEntry(c, "large", make([]byte, 25))
+
+	if entries := c.Stats().Entries; entries != 2 {
+		t.Errorf("expected 2 entries left, got %d", entries)
+	}
+	if _, exists := storedEntry(c, "large"); !exists {
+		t.Error("expected large entry to be cached")
+	}
+	if c.Stats().Evictions != 2 {
+		t.Errorf("expected 2 evictions, got %d", c.Stats().Evictions)
+	}
+}
+
+func TestSetRejectsEntriesLargerThanTheCache(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	c.MaxSize = 10
+
+	setTestEntry(c, "small", make([]byte, 5))
+	setTestEntry(c, "huge", make([]byte, 20))
+
+	if _, exists := storedEntry(c, "small"); !exists {
+		t.Error("expected existing entries to be kept when a new one can never fit")
+	}
+	if _, exists := storedEntry(c, "huge"); exists {
+		t.Error("expected entry larger than the cache to be rejected")
+	}
+}
+
+func setStaleTestEntry(c *HttpCache, path string, header http.Header) *http.Request {
+	rec := httptest.NewRecorder()
+	resp := NewCachableResponse(rec)
+	for k, v := range header {
+		resp.Header()[k] = v
+	}
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte("stale body"))
+
+	req := &http.Request{URL: &url.URL{Path: path}}
+	c.Set(req, resp, time.Now().Add(-time.Second))
+
+	return req
+}
+
+func TestLookupKeepsRevalidatableStaleEntries(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	req := setStaleTestEntry(c, "stale", http.Header{"Etag": []string{`"v1"`}})
+
+	if _, found := c.Get(req); found {
+		t.Error("expected Get to ignore stale entries")
+	}
+
+	entry, found := c.Lookup(req)
+	if !found {
+		t.Fatal("expected Lookup to return the stale entry")
+	}
+	if !entry.IsExpired() || string(entry.Body) != "stale body" {
+		t.Errorf("unexpected stale entry: %+v", entry)
+	}
+
+	if entries := c.Stats().Entries; entries != 1 {
+		t.Errorf("expected the stale entry to be kept, got %d entries", entries)
+	}
+}
+
+func TestLookupDropsStaleEntriesWithoutValidators(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	req := setStaleTestEntry(c, "stale", nil)
+
+	if _, found := c.Lookup(req); found {
+		t.Error("expected stale entry without validators to be dropped")
+	}
+
+	if stats := c.Stats(); stats.Entries != 0 || stats.CurrentSize != 0 {
+		t.Errorf("expected empty cache, got %d entries and %d bytes", stats.Entries, stats.CurrentSize)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	req := setStaleTestEntry(c, "stale", http.Header{
+		"Etag":          []string{`"v1"`},
+		"Cache-Control": []string{"no-cache"},
+	})
+
+	stale, _ := c.Lookup(req)
+	refreshed := stale.Revalidated(http.Header{
+		"Cache-Control":  []string{"max-age=60"},
+		"Content-Length": []string{"0"},
+		"X-Origin":       []string{"refreshed"},
+	})
+	refreshed.ExpiresAt = time.Now().Add(time.Minute)
+
+	if !c.Refresh(req, refreshed) {
+		t.Fatal("expected Refresh to update the stored entry")
+	}
+
+	entry, found := c.Get(req)
+	if !found {
+		t.Fatal("expected refreshed entry to be fresh")
+	}
+
+	if string(entry.Body) != "stale body" {
+		t.Errorf("expected stored body to be kept, got %q", entry.Body)
+	}
+	if entry.Header.Get("Cache-Control") != "max-age=60" || entry.Header.Get("X-Origin") != "refreshed" {
+		t.Errorf("expected headers to be merged, got %v", entry.Header)
+	}
+	if entry.Header.Get("Etag") != `"v1"` || entry.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("expected stored headers to be kept, got %v", entry.Header)
+	}
+	if entry.Header.Get("Content-Length") != "" {
+		t.Errorf("expected Content-Length of the 304 to be ignored, got %v", entry.Header)
+	}
+
+	if c.Refresh(&http.Request{URL: &url.URL{Path: "missing"}}, refreshed) {
+		t.Error("expected Refresh of a missing entry to fail")
+	}
+}
+
+func TestLookupKeepsEntriesWithinStaleWindow(t *testing.T) {
+	c := NewEmptyCacheWithOptions(1, 1, Options{Stale: StalePolicy{IfError: time.Minute}})
+	t.Cleanup(func() { c.Cleanup() })
+
+	req := setStaleTestEntry(c, "stale", nil)
+	entry, found := c.Lookup(req)
+	if !found {
+		t.Fatal("expected entry within the stale-if-error window to be kept")
+	}
+
+	if !entry.IsStaleWithin(c.StalePolicy(entry).IfError) {
+		t.Error("expected entry to be servable on errors")
+	}
+
+	if entry.IsStaleWithin(c.StalePolicy(entry).WhileRevalidate) {
+		t.Error("expected entry not to be servable while revalidating")
+	}
+
+	outdated := setStaleTestEntry(c, "outdated", http.Header{"Cache-Control": []string{"stale-if-error=0"}})
+	if _, found := c.Lookup(outdated); found {
+		t.Error("expected response directives to override the cache defaults")
+	}
+}
+
+func TestGetSharesTheStoredBody(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	req := setTestEntry(c, "shared", []byte("shared body"))
+
+	first, _ := c.Get(req)
+	second, _ := c.Get(req)
+	if &first.Body[0] != &second.Body[0] {
+		t.Error("expected hits to share the stored body")
+	}
+
+	first.Header.Set("X-Cache", "STALE")
+	if stored, _ := storedEntry(c, c.KeyFor(req)); stored.Header.Get("X-Cache") != "HIT" {
+		t.Error("expected the headers of a hit to be copied")
+	}
+}
+
+func TestGetDoesNotCopyBodies(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	c.MaxSize = MiBToBytes(2)
+	req := setTestEntry(c, "large", make([]byte, MiBToBytes(1)))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 100; i++ {
+		if _, found := c.Get(req); !found {
+			t.Fatal("expected entry to be found")
+		}
+	}
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(MiBToBytes(1)) {
+		t.Errorf("expected hits not to copy the body, %d bytes allocated", allocated)
+	}
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+// BenchmarkCacheHit serves a hit per iteration, allocations do not depend on
+// the body size.
+func BenchmarkCacheHit(b *testing.B) {
+	for _, size := range []int{1 << 10, 64 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("body=%dKiB", size>>10), func(b *testing.B) {
+			c := NewEmptyCache(2, 2)
+			defer c.Cleanup()
+
+			req := setTestEntry(c, "hit", make([]byte, size))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				entry, _ := c.Get(req)
+				_ = entry.WriteResponse(&discardResponseWriter{header: make(http.Header)}, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func IsUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+
+	return true
+}
+
+// InvalidationTargets returns, once an unsafe request succeeded, requests for
+// its target URI and for the Location and Content-Location URIs on the same
+// host, whose cached entries are now outdated (RFC 9111 §4.4).
+func InvalidationTargets(r *http.Request, statusCode int, h http.Header) []*http.Request {
+	if !IsUnsafeMethod(r.Method) || statusCode < http.StatusOK || statusCode >= http.StatusBadRequest {
+		return nil
+	}
+
+	base := &url.URL{
+		Scheme:   requestScheme(r),
+		Host:     requestHost(r),
+		Path:     r.URL.Path,
+		RawPath:  r.URL.RawPath,
+		RawQuery: r.URL.RawQuery,
+	}
+
+	targets := []*http.Request{targetRequest(r, base)}
+	seen := map[string]struct{}{base.String(): {}}
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := h.Get(name)
+		if value == "" {
+			continue
+		}
+
+		ref, err := url.Parse(value)
+		if err != nil {
+			continue
+		}
+
+		resolved := base.ResolveReference(ref)
+		if !strings.EqualFold(resolved.Host, base.Host) {
+			continue
+		}
+
+		resolved.Fragment = ""
+		if _, exists := seen[resolved.String()]; exists {
+			continue
+		}
+		seen[resolved.String()] = struct{}{}
+
+		targets = append(targets, targetRequest(r, resolved))
+	}
+
+	return targets
+}
+
+func targetRequest(r *http.Request, target *url.URL) *http.Request {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Body = http.NoBody
+
+	u := *r.URL
+	u.Path = target.Path
+	u.RawPath = target.RawPath
+	u.RawQuery = target.RawQuery
+	req.URL = &u
+
+	return req
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInvalidationTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		status   int
+		header   map[string]string
+		expected []string
+	}{
+		{"safe method", "GET", 200, nil, nil},
+		{"failed request", "PUT", 500, nil, nil},
+		{"client error", "DELETE", 404, nil, nil},
+		{"put", "PUT", 204, nil, []string{"http://example.com/items/1?v=1"}},
+		{"post with location", "POST", 201, map[string]string{"Location": "/items/2"},
+			[]string{"http://example.com/items/1?v=1", "http://example.com/items/2"}},
+		{"relative content-location", "PATCH", 200, map[string]string{"Content-Location": "3"},
+			[]string{"http://example.com/items/1?v=1", "http://example.com/items/3"}},
+		{"absolute same host location", "POST", 303, map[string]string{"Location": "http://EXAMPLE.com/items/4#top"},
+			[]string{"http://example.com/items/1?v=1", "http://example.com/items/4"}},
+		{"other host location", "POST", 201, map[string]string{"Location": "http://other.com/items/2"},
+			[]string{"http://example.com/items/1?v=1"}},
+		{"duplicate location", "POST", 201, map[string]string{"Location": "/items/1?v=1", "Content-Location": "/items/1?v=1"},
+			[]string{"http://example.com/items/1?v=1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/items/1?v=1", nil)
+			r.Host = "example.com"
+
+			h := make(http.Header)
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+
+			targets := InvalidationTargets(r, tt.status, h)
+			if len(targets) != len(tt.expected) {
+				t.Fatalf("expected %d targets, got %d", len(tt.expected), len(targets))
+			}
+
+			for i, target := range targets {
+				if target.Method != http.MethodGet {
+					t.Errorf("expected GET target, got %s", target.Method)
+				}
+				if key := KeyFrom(target); key != tt.expected[i] {
+					t.Errorf("target %d: expected %s, got %s", i, tt.expected[i], key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+type Key = string
+
+const DefaultKeyTemplate = "{scheme}://{host}{path}{query}"
+
+var keyPlaceholders = []string{"{scheme}", "{host}", "{path}", "{query}", "{headers}", "{cookies}"}
+
+// keyValueEscaper escapes the separators of the {headers} and {cookies}
+// placeholders in values, so that distinct values never build the same key.
+var keyValueEscaper = strings.NewReplacer("%", "%25", "|", "%7C", ";", "%3B", "=", "%3D")
+
+type KeyConfig struct {
+	Template     string
+	IncludeQuery []string // glob patterns, every parameter when empty
+	ExcludeQuery []string // glob patterns
+	Headers      []string
+	Cookies      []string
+}
+
+type KeyBuilder struct {
+	template     string
+	includeQuery []string
+	excludeQuery []string
+	headers      []string
+	cookies      []string
+}
+
+func NewKeyBuilder(cfg KeyConfig) (*KeyBuilder, error) {
+	template := cfg.Template
+	if template == "" {
+		template = DefaultKeyTemplate
+		if len(cfg.Headers) > 0 {
+			template += "|{headers}"
+		}
+		if len(cfg.Cookies) > 0 {
+			template += "|{cookies}"
+		}
+	}
+
+	if err := validateKeyTemplate(template); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Headers) > 0 && !strings.Contains(template, "{headers}") {
+		return nil, fmt.Errorf("cache key template %q has no {headers} placeholder for the configured headers", template)
+	}
+	if len(cfg.Cookies) > 0 && !strings.Contains(template, "{cookies}") {
+		return nil, fmt.Errorf("cache key template %q has no {cookies} placeholder for the configured cookies", template)
+	}
+
+	for _, pattern := range append(slices.Clone(cfg.IncludeQuery), cfg.ExcludeQuery...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid query parameter pattern %q: %w", pattern, err)
+		}
+	}
+
+	headers := make([]string, 0, len(cfg.Headers))
+	for _, h := range cfg.Headers {
+		headers = append(headers, http.CanonicalHeaderKey(h))
+	}
+	slices.Sort(headers)
+
+	cookies := slices.Clone(cfg.Cookies)
+	slices.Sort(cookies)
+
+	return &KeyBuilder{
+		template:     template,
+		includeQuery: cfg.IncludeQuery,
+		excludeQuery: cfg.ExcludeQuery,
+		headers:      headers,
+		cookies:      cookies,
+	}, nil
+}
+
+func validateKeyTemplate(template string) error {
+	rest := template
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			return nil
+		}
+
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return fmt.Errorf("unterminated placeholder in cache key template %q", template)
+		}
+
+		placeholder := rest[start : start+end+1]
+		if !slices.Contains(keyPlaceholders, placeholder) {
+			return fmt.Errorf("unknown placeholder %s in cache key template %q", placeholder, template)
+		}
+
+		rest = rest[start+end+1:]
+	}
+}
+
+func (b *KeyBuilder) Key(r *http.Request) Key {
+	replacer := strings.NewReplacer(
+		"{scheme}", requestScheme(r),
+		"{host}", strings.ToLower(requestHost(r)),
+		"{path}", r.URL.EscapedPath(),
+		"{query}", b.query(r.URL.Query()),
+		"{headers}", b.headerValues(r),
+		"{cookies}", b.cookieValues(r),
+	)
+
+	return replacer.Replace(b.template)
+}
+
+func (b *KeyBuilder) query(values url.Values) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		if b.keepQueryParameter(name) {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	slices.Sort(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		params := slices.Clone(values[name])
+		slices.Sort(params)
+		for _, value := range params {
+			if sb.Len() == 0 {
+				sb.WriteByte('?')
+			} else {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(url.QueryEscape(name))
+			sb.WriteByte('=')
+			sb.WriteString(url.QueryEscape(value))
+		}
+	}
+
+	return sb.String()
+}
+
+func (b *KeyBuilder) keepQueryParameter(name string) bool {
+	if matchesAny(b.excludeQuery, name) {
+		return false
+	}
+
+	return len(b.includeQuery) == 0 || matchesAny(b.includeQuery, name)
+}
+
+func (b *KeyBuilder) headerValues(r *http.Request) string {
+	parts := make([]string, 0, len(b.headers))
+	for _, name := range b.headers {
+		parts = append(parts, name+"="+keyValueEscaper.Replace(normalizeHeaderValue(r.Header.Values(name))))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+func (b *KeyBuilder) cookieValues(r *http.Request) string {
+	parts := make([]string, 0, len(b.cookies))
+	for _, name := range b.cookies {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		parts = append(parts, name+"="+keyValueEscaper.Replace(value))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// KeyFrom is the key used when a cache has no key builder: the request URL,
+// qualified with the scheme and the Host the request was sent to.
+func KeyFrom(request *http.Request) Key {
+	u := *request.URL
+	if u.Host == "" {
+		u.Host = request.Host
+	}
+	if u.Host != "" && u.Scheme == "" {
+		u.Scheme = requestScheme(request)
+	}
+
+	return u.String()
+}
+
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+
+	return r.URL.Host
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyFromIncludesHost(t *testing.T) {
+	a := httptest.NewRequest("GET", "/resource?b=2", nil)
+	a.Host = "a.example.com"
+	b := httptest.NewRequest("GET", "/resource?b=2", nil)
+	b.Host = "b.example.com"
+
+	if KeyFrom(a) == KeyFrom(b) {
+		t.Errorf("expected different hosts to produce different keys, got %s", KeyFrom(a))
+	}
+	if got := KeyFrom(a); got != "http://a.example.com/resource?b=2" {
+		t.Errorf("unexpected key %s", got)
+	}
+}
+
+func TestKeyBuilder(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      KeyConfig
+		target   string
+		setup    func(r *http.Request)
+		expected Key
+	}{
+		{
+			name:     "default template sorts query parameters",
+			target:   "http://Example.com/items?b=2&a=1&a=0",
+			expected: "http://example.com/items?a=0&a=1&b=2",
+		},
+		{
+			name:   "https scheme",
+			target: "/items",
+			setup: func(r *http.Request) {
+				r.URL.Scheme = ""
+				r.Host = "example.com"
+				r.TLS = &tls.ConnectionState{}
+			},
+			expected: "https://example.com/items",
+		},
+		{
+			name:     "excluded parameters",
+			cfg:      KeyConfig{ExcludeQuery: []string{"utm_*", "fbclid"}},
+			target:   "http://example.com/items?utm_source=x&utm_medium=y&fbclid=z&page=2",
+			expected: "http://example.com/items?page=2",
+		},
+		{
+			name:     "included parameters",
+			cfg:      KeyConfig{IncludeQuery: []string{"page", "sort"}},
+			target:   "http://example.com/items?session=abc&sort=name&page=2",
+			expected: "http://example.com/items?page=2&sort=name",
+		},
+		{
+			name:   "headers and cookies",
+			cfg:    KeyConfig{Headers: []string{"x-tenant"}, Cookies: []string{"region"}},
+			target: "http://example.com/items",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Tenant", "acme")
+				r.AddCookie(&http.Cookie{Name: "region", Value: "eu"})
+			},
+			expected: "http://example.com/items|X-Tenant=acme|region=eu",
+		},
+		{
+			name:   "separators in values are escaped",
+			cfg:    KeyConfig{Headers: []string{"X-Tenant"}, Cookies: []string{"region"}},
+			target: "http://example.com/items",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Tenant", "acme|region=eu")
+				r.AddCookie(&http.Cookie{Name: "region", Value: "a=b%"})
+			},
+			expected: "http://example.com/items|X-Tenant=acme%7Cregion%3Deu|region=a%3Db%25",
+		},
+		{
+			name:     "custom template ignoring host",
+			cfg:      KeyConfig{Template: "{path}{query}#{headers}", Headers: []string{"X-Tenant"}},
+			target:   "http://example.com/items?a=1",
+			setup:    func(r *http.Request) { r.Header.Set("X-Tenant", "acme") },
+			expected: "/items?a=1#X-Tenant=acme",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder, err := NewKeyBuilder(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+
+			if got := builder.Key(r); got != tt.expected {
+				t.Errorf("expected key %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewKeyBuilderRejectsInvalidConfiguration(t *testing.T) {
+	invalid := []KeyConfig{
+		{Template: "{path}{unknown}"},
+		{Template: "{path"},
+		{ExcludeQuery: []string{"[utm"}},
+		{Template: "{path}{query}", Headers: []string{"X-Tenant"}},
+		{Template: "{path}{headers}", Headers: []string{"X-Tenant"}, Cookies: []string{"session"}},
+	}
+
+	for _, cfg := range invalid {
+		if _, err := NewKeyBuilder(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package cache
+
+import "container/list"
+
+type lfuItem struct {
+	key       Key
+	frequency int
+	bucket    *list.Element
+}
+
+// lfuPolicy evicts the least frequently used key in O(1), ties are broken by
+// recency. Keys are grouped in buckets of equal frequency, sorted ascending.
+type lfuPolicy struct {
+	items   map[Key]*list.Element
+	buckets *list.List
+}
+
+type lfuBucket struct {
+	frequency int
+	items     *list.List
+}
+
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{
+		items:   make(map[Key]*list.Element),
+		buckets: list.New(),
+	}
+}
+
+func (p *lfuPolicy) Add(key Key, _ int) {
+	if _, exists := p.items[key]; exists {
+		p.Touch(key)
+		return
+	}
+
+	first := p.buckets.Front()
+	if first == nil || first.Value.(*lfuBucket).frequency != 1 {
+		first = p.buckets.PushFront(&lfuBucket{frequency: 1, items: list.New()})
+	}
+
+	bucket := first.Value.(*lfuBucket)
+	p.items[key] = bucket.items.PushFront(&lfuItem{key: key, frequency: 1, bucket: first})
+}
+
+func (p *lfuPolicy) Touch(key Key) {
+	element, exists := p.items[key]
+	if !exists {
+		return
+	}
+
+	item := element.Value.(*lfuItem)
+	current := item.bucket
+	next := current.Next()
+	if next == nil || next.Value.(*lfuBucket).frequency != item.frequency+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{frequency: item.frequency + 1, items: list.New()}, current)
+	}
+
+	p.detach(element)
+	item.frequency++
+	item.bucket = next
+	p.items[key] = next.Value.(*lfuBucket).items.PushFront(item)
+}
+
+func (p *lfuPolicy) Remove(key Key) {
+	element, exists := p.items[key]
+	if !exists {
+		return
+	}
+
+	p.detach(element)
+	delete(p.items, key)
+}
+
+func (p *lfuPolicy) Evict(Key, int) (Key, bool) {
+	first := p.buckets.Front()
+	if first == nil {
+		return "", false
+	}
+
+	victim := first.Value.(*lfuBucket).items.Back().Value.(*lfuItem).key
+	p.Remove(victim)
+
+	return victim, true
+}
+
+func (p *lfuPolicy) evictionOrder() []Key {
+	keys := make([]Key, 0, len(p.items))
+	for bucket := p.buckets.Front(); bucket != nil; bucket = bucket.Next() {
+		items := bucket.Value.(*lfuBucket).items
+		for element := items.Back(); element != nil; element = element.Prev() {
+			keys = append(keys, element.Value.(*lfuItem).key)
+		}
+	}
+
+	return keys
+}
+
+func (p *lfuPolicy) detach(element *list.Element) {
+	item := element.Value.(*lfuItem)
+	bucket := item.bucket.Value.(*lfuBucket)
+	bucket.items.Remove(element)
+	if bucket.items.Len() == 0 {
+		p.buckets.Remove(item.bucket)
+	}
+}
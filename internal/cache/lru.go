@@ -0,0 +1,126 @@
+package cache
+
+import "container/list"
+
+type lruItem struct {
+	key  Key
+	size int
+}
+
+// lruList is a recency ordered list of keys, most recent first, which also
+// keeps track of the total size of the keys it holds.
+type lruList struct {
+	order    *list.List
+	elements map[Key]*list.Element
+	bytes    int
+}
+
+func newLRUList() *lruList {
+	return &lruList{
+		order:    list.New(),
+		elements: make(map[Key]*list.Element),
+	}
+}
+
+func (l *lruList) add(key Key, size int) {
+	if element, exists := l.elements[key]; exists {
+		item := element.Value.(*lruItem)
+		l.bytes += size - item.size
+		item.size = size
+		l.order.MoveToFront(element)
+		return
+	}
+
+	l.elements[key] = l.order.PushFront(&lruItem{key: key, size: size})
+	l.bytes += size
+}
+
+func (l *lruList) contains(key Key) bool {
+	_, exists := l.elements[key]
+	return exists
+}
+
+func (l *lruList) touch(key Key) bool {
+	element, exists := l.elements[key]
+	if exists {
+		l.order.MoveToFront(element)
+	}
+
+	return exists
+}
+
+func (l *lruList) remove(key Key) (int, bool) {
+	element, exists := l.elements[key]
+	if !exists {
+		return 0, false
+	}
+
+	item := element.Value.(*lruItem)
+	l.order.Remove(element)
+	delete(l.elements, key)
+	l.bytes -= item.size
+
+	return item.size, true
+}
+
+func (l *lruList) oldest() (Key, int, bool) {
+	element := l.order.Back()
+	if element == nil {
+		return "", 0, false
+	}
+
+	item := element.Value.(*lruItem)
+	return item.key, item.size, true
+}
+
+func (l *lruList) popOldest() (Key, int, bool) {
+	key, size, found := l.oldest()
+	if found {
+		l.remove(key)
+	}
+
+	return key, size, found
+}
+
+// keys lists the keys from the oldest to the most recent.
+func (l *lruList) keys() []Key {
+	keys := make([]Key, 0, l.order.Len())
+	for element := l.order.Back(); element != nil; element = element.Prev() {
+		keys = append(keys, element.Value.(*lruItem).key)
+	}
+
+	return keys
+}
+
+func (l *lruList) len() int {
+	return l.order.Len()
+}
+
+type lruPolicy struct {
+	entries *lruList
+}
+
+func NewLRUPolicy() EvictionPolicy {
+	return &lruPolicy{entries: newLRUList()}
+}
+
+func (p *lruPolicy) Add(key Key, size int) {
+	p.entries.add(key, size)
+}
+
+func (p *lruPolicy) Touch(key Key) {
+	p.entries.touch(key)
+}
+
+func (p *lruPolicy) Remove(key Key) {
+	p.entries.remove(key)
+}
+
+func (p *lruPolicy) Evict(Key, int) (Key, bool) {
+	key, _, found := p.entries.popOldest()
+	return key, found
+}
+
+func (p *lruPolicy) evictionOrder() []Key {
+	return p.entries.keys()
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// PurgeSelector tells which entries a purge removes, an entry matching any of
+// the set fields is removed.
+type PurgeSelector struct {
+	Key    Key      // a single key, with all its variants
+	Prefix string   // request path prefix
+	Glob   string   // pattern matched against keys, * spans any sequence and ? a single character
+	Tags   []string // Surrogate-Key or Cache-Tag values
+}
+
+var ErrEmptyPurgeSelector = errors.New("purge selector needs a key, a prefix, a glob or tags")
+
+// maxPurgeLog is the number of purges remembered to fence fills, fills
+// started before the oldest of them are never stored.
+const maxPurgeLog = 1024
+
+// purgeRecord is a purge remembered so that fills started before it only lose
+// the responses it matches.
+type purgeRecord struct {
+	generation uint64
+	selector   PurgeSelector
+	glob       *regexp.Regexp
+}
+
+func (s PurgeSelector) IsZero() bool {
+	return s.Key == "" && s.Prefix == "" && s.Glob == "" && len(s.Tags) == 0
+}
+
+// Validate returns the error a purge of the selector fails with before
+// touching any entry.
+func (s PurgeSelector) Validate() error {
+	if s.IsZero() {
+		return ErrEmptyPurgeSelector
+	}
+	if s.Glob != "" {
+		if _, err := compileGlob(s.Glob); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Purge removes every entry matched by the selector, and makes the matching
+// responses fetched before it unstorable through SetAt. The purge reaches the
+// shared store too.
+func (c *HttpCache) Purge(selector PurgeSelector) (int, error) {
+	purged, err := c.PurgeLocal(selector)
+	if err != nil || c.shared == nil {
+		return purged, err
+	}
+
+	sharedPurged, err := c.shared.Purge(selector)
+
+	return purged + sharedPurged, err
+}
+
+// PurgeLocal is Purge leaving the shared store alone, for purges another
+// instance already applied to it.
+func (c *HttpCache) PurgeLocal(selector PurgeSelector) (int, error) {
+	if selector.IsZero() {
+		return 0, ErrEmptyPurgeSelector
+	}
+
+	var glob *regexp.Regexp
+	if selector.Glob != "" {
+		var err error
+		if glob, err = compileGlob(selector.Glob); err != nil {
+			return 0, err
+		}
+	}
+
+	c.purgeMu.Lock()
+	c.purges = append(c.purges, purgeRecord{generation: c.generation.Add(1), selector: selector, glob: glob})
+	if len(c.purges) > maxPurgeLog {
+		c.purges = slices.Delete(c.purges, 0, len(c.purges)-maxPurgeLog)
+	}
+	c.purgeMu.Unlock()
+
+	purged := 0
+
+	if selector.Key != "" {
+		s := c.shardFor(selector.Key)
+		c.update(s, func() {
+			purged += c.deleteVariantsLocked(s, selector.Key)
+		})
+	}
+
+	for _, s := range c.shards {
+		c.update(s, func() {
+			purged += c.purgeShardLocked(s, selector, glob)
+		})
+	}
+
+	return purged, nil
+}
+
+func (c *HttpCache) purgeShardLocked(s *shard, selector PurgeSelector, glob *regexp.Regexp) int {
+	purged := 0
+
+	for _, tag := range selector.Tags {
+		for key := range s.tags[tag] {
+			if c.deleteLocked(s, key) {
+				purged++
+			}
+		}
+	}
+
+	if selector.Prefix == "" && glob == nil {
+		return purged
+	}
+
+	purgeMatching := func(key Key, entry Entry) {
+		primary := s.primaryLocked(key)
+		if (selector.Prefix != "" && strings.HasPrefix(entry.Path, selector.Prefix)) || (glob != nil && glob.MatchString(primary)) {
+			if c.deleteLocked(s, key) {
+				purged++
+			}
+		}
+	}
+
+	for key, entry := range s.entries {
+		purgeMatching(key, entry)
+	}
+	c.eachOnDisk(func(key Key, meta Entry) {
+		if c.shardFor(key) == s {
+			purgeMatching(key, meta)
+		}
+	})
+
+	return purged
+}
+
+// Generation changes on every purge.
+func (c *HttpCache) Generation() uint64 {
+	return c.generation.Load()
+}
+
+// purgedSince reports whether a purge made after generation matches the entry
+// stored under primary. Purges are recorded before they delete anything, so
+// an entry stored after this check is either not matched or deleted by them.
+func (c *HttpCache) purgedSince(generation uint64, primary Key, entry *Entry) bool {
+	if c.generation.Load() == generation {
+		return false
+	}
+
+	c.purgeMu.Lock()
+	defer c.purgeMu.Unlock()
+
+	if len(c.purges) == 0 || c.purges[0].generation > generation+1 {
+		return true
+	}
+
+	for i := len(c.purges) - 1; i >= 0 && c.purges[i].generation > generation; i-- {
+		if c.purges[i].matches(primary, entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *purgeRecord) matches(primary Key, entry *Entry) bool {
+	switch {
+	case p.selector.Key != "" && p.selector.Key == primary:
+		return true
+	case p.selector.Prefix != "" && strings.HasPrefix(entry.Path, p.selector.Prefix):
+		return true
+	case p.glob != nil && p.glob.MatchString(primary):
+		return true
+	}
+
+	for _, tag := range Tags(entry.Header) {
+		if slices.Contains(p.selector.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.Compile(expr.String())
+}
+
+// Tags reads the Surrogate-Key (space separated) and Cache-Tag (comma
+// separated) values of a header.
+func Tags(h http.Header) []string {
+	var tags []string
+	for _, value := range h.Values("Surrogate-Key") {
+		tags = append(tags, strings.Fields(value)...)
+	}
+
+	for _, value := range h.Values("Cache-Tag") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+func (s *shard) tagLocked(key Key, entry Entry) {
+	for _, tag := range Tags(entry.Header) {
+		keys, exists := s.tags[tag]
+		if !exists {
+			keys = make(map[Key]struct{})
+			s.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (s *shard) untagLocked(key Key, entry Entry) {
+	for _, tag := range Tags(entry.Header) {
+		if keys, exists := s.tags[tag]; exists {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
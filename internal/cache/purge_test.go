@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func setTaggedEntry(c *HttpCache, target string, header map[string]string) *http.Request {
+	resp := NewCachableResponse(httptest.NewRecorder())
+	for k, v := range header {
+		resp.Header().Set(k, v)
+	}
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte(target))
+
+	r := httptest.NewRequest("GET", target, nil)
+	c.Set(r, resp, time.Now().Add(ttl))
+
+	return r
+}
+
+func TestPurge(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector PurgeSelector
+		purged   int
+		kept     []string
+	}{
+		{"key", PurgeSelector{Key: "http://example.com/api/users/1"}, 1,
+			[]string{"http://example.com/api/users/2", "http://example.com/api/posts/1", "http://other.com/static/app.js"}},
+		{"prefix", PurgeSelector{Prefix: "/api/users/"}, 2,
+			[]string{"http://example.com/api/posts/1", "http://other.com/static/app.js"}},
+		{"glob", PurgeSelector{Glob: "http://*/api/*/1"}, 2,
+			[]string{"http://example.com/api/users/2", "http://other.com/static/app.js"}},
+		{"surrogate key", PurgeSelector{Tags: []string{"user-1"}}, 1,
+			[]string{"http://example.com/api/users/2", "http://example.com/api/posts/1", "http://other.com/static/app.js"}},
+		{"cache tag", PurgeSelector{Tags: []string{"assets"}}, 1,
+			[]string{"http://example.com/api/users/1", "http://example.com/api/users/2", "http://example.com/api/posts/1"}},
+		{"shared tag", PurgeSelector{Tags: []string{"users"}}, 2,
+			[]string{"http://example.com/api/posts/1", "http://other.com/static/app.js"}},
+		{"union", PurgeSelector{Key: "http://example.com/api/posts/1", Tags: []string{"assets"}}, 2,
+			[]string{"http://example.com/api/users/1", "http://example.com/api/users/2"}},
+		{"nothing matches", PurgeSelector{Prefix: "/nope"}, 0,
+			[]string{"http://example.com/api/users/1", "http://example.com/api/users/2", "http://example.com/api/posts/1", "http://other.com/static/app.js"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newEmptyConfiguredCache(t)
+			setTaggedEntry(c, "http://example.com/api/users/1", map[string]string{"Surrogate-Key": "users user-1"})
+			setTaggedEntry(c, "http://example.com/api/users/2", map[string]string{"Surrogate-Key": "users user-2"})
+			setTaggedEntry(c, "http://example.com/api/posts/1", nil)
+			setTaggedEntry(c, "http://other.com/static/app.js", map[string]string{"Cache-Tag": "assets, js"})
+
+			purged, err := c.Purge(tt.selector)
+			if err != nil {
+				t.Fatalf("Purge returned error: %v", err)
+			}
+
+			if purged != tt.purged {
+				t.Errorf("expected %d purged entries, got %d", tt.purged, purged)
+			}
+
+			if entries := c.Stats().Entries; entries != len(tt.kept) {
+				t.Errorf("expected %d entries left, got %d", len(tt.kept), entries)
+			}
+			for _, key := range tt.kept {
+				if _, exists := storedEntry(c, key); !exists {
+					t.Errorf("expected %s to be kept", key)
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeEmptySelector(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	if _, err := c.Purge(PurgeSelector{}); !errors.Is(err, ErrEmptyPurgeSelector) {
+		t.Errorf("expected ErrEmptyPurgeSelector, got %v", err)
+	}
+}
+
+func TestPurgeKeyDropsEveryVariant(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Language": "en"}), "Accept-Language", "hello")
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Language": "fr"}), "Accept-Language", "bonjour")
+
+	if purged, _ := c.Purge(PurgeSelector{Key: "http://example.com/resource"}); purged != 2 {
+		t.Errorf("expected both variants to be purged, got %d", purged)
+	}
+
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Language": "en"}), "Accept-Language", "hello")
+	if purged, _ := c.Purge(PurgeSelector{Glob: "*/resource"}); purged != 1 {
+		t.Errorf("expected globs to match variants through their primary key, got %d", purged)
+	}
+}
+
+func TestTagIndexFollowsEntries(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	r := setTaggedEntry(c, "http://example.com/a", map[string]string{"Surrogate-Key": "old"})
+	setTaggedEntry(c, "http://example.com/a", map[string]string{"Surrogate-Key": "new"})
+
+	s := c.shardFor(c.KeyFor(r))
+	if _, exists := s.tags["old"]; exists {
+		t.Error("expected replaced entry tags to be dropped")
+	}
+
+	c.Invalidate(r)
+	if len(s.tags) != 0 {
+		t.Errorf("expected deleted entry tags to be dropped, got %v", s.tags)
+	}
+}
+
+func TestSetAtRejectsResponsesFetchedBeforeAPurge(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	generation := c.Generation()
+	if _, err := c.Purge(PurgeSelector{Prefix: "/"}); err != nil {
+		t.Fatalf("Purge returned error: %v", err)
+	}
+
+	resp := NewCachableResponse(httptest.NewRecorder())
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte("outdated"))
+	r := httptest.NewRequest("GET", "http://example.com/a", nil)
+
+	if c.SetAt(generation, r, resp, time.Now().Add(ttl)) {
+		t.Error("expected a response fetched before the purge not to be stored")
+	}
+
+	if !c.SetAt(c.Generation(), r, resp, time.Now().Add(ttl)) {
+		t.Error("expected a response fetched after the purge to be stored")
+	}
+}
+
+func TestSetAtOnlyRejectsResponsesMatchedByLaterPurges(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	generation := c.Generation()
+
+	for _, selector := range []PurgeSelector{
+		{Key: "http://example.com/purged-key"},
+		{Prefix: "/purged/"},
+		{Glob: "http://example.com/*.css"},
+		{Tags: []string{"purged"}},
+	} {
+		if _, err := c.Purge(selector); err != nil {
+			t.Fatalf("Purge returned error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		target string
+		tags   string
+		stored bool
+	}{
+		{"http://example.com/unrelated", "kept", true},
+		{"http://example.com/purged-key", "", false},
+		{"http://example.com/purged/page", "", false},
+		{"http://example.com/style.css", "", false},
+		{"http://example.com/tagged", "kept purged", false},
+	}
+
+	for _, tt := range tests {
+		resp := NewCachableResponse(httptest.NewRecorder())
+		if tt.tags != "" {
+			resp.Header().Set("Surrogate-Key", tt.tags)
+		}
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte("body"))
+
+		if stored := c.SetAt(generation, httptest.NewRequest("GET", tt.target, nil), resp, time.Now().Add(ttl)); stored != tt.stored {
+			t.Errorf("%s: expected stored to be %v, got %v", tt.target, tt.stored, stored)
+		}
+	}
+}
+
+func TestSetAtRejectsResponsesOlderThanThePurgeLog(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	generation := c.Generation()
+
+	for i := 0; i <= maxPurgeLog; i++ {
+		if _, err := c.Purge(PurgeSelector{Key: fmt.Sprintf("http://example.com/%d", i)}); err != nil {
+			t.Fatalf("Purge returned error: %v", err)
+		}
+	}
+
+	resp := NewCachableResponse(httptest.NewRecorder())
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte("body"))
+
+	if c.SetAt(generation, httptest.NewRequest("GET", "http://example.com/unrelated", nil), resp, time.Now().Add(ttl)) {
+		t.Error("expected a fill older than every remembered purge not to be stored")
+	}
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds the number of ranges served from a single request, requests
+// asking for more are answered with the whole body.
+const maxRanges = 32
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+type byteRange struct {
+	start, end int // inclusive bounds
+}
+
+func (br byteRange) contentRange(size int) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, size)
+}
+
+// servesRange tells if a Range request can be answered from the entry, only
+// complete 200 responses are sliced (RFC 9110 §14.2).
+func (entry *Entry) servesRange(r *http.Request) bool {
+	if r == nil || r.Method != http.MethodGet || r.Header.Get("Range") == "" || entry.StatusCode != http.StatusOK {
+		return false
+	}
+
+	return ifRangeMatches(r.Header.Get("If-Range"), entry.Header)
+}
+
+// ifRangeMatches evaluates If-Range, a mismatch means the whole body has to be
+// sent (RFC 9110 §13.1.5).
+func ifRangeMatches(ifRange string, h http.Header) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || isWeakETag(ifRange) {
+		return etagsMatchStrong(ifRange, h.Get("ETag"))
+	}
+
+	date, ok := parseHTTPDate(ifRange)
+	lastModified, hasLastModified := parseHTTPDate(h.Get("Last-Modified"))
+
+	return ok && hasLastModified && date.Equal(lastModified)
+}
+
+// writeRanges answers a Range request with the requested slices of the body,
+// as a single part or a multipart/byteranges response. Invalid range headers
+// are ignored and get the whole body.
+func (entry *Entry) writeRanges(w http.ResponseWriter, r *http.Request) error {
+	body := entry.Body
+	if entry.Encoding != EncodingIdentity {
+		var decoded bytes.Buffer
+		if err := decompress(&decoded, entry.Encoding, entry.Body); err != nil {
+			return err
+		}
+		body = decoded.Bytes()
+		addVary(w.Header(), "Accept-Encoding")
+	}
+
+	h := w.Header()
+	ranges, err := parseRange(r.Header.Get("Range"), len(body))
+	switch {
+	case errors.Is(err, errUnsatisfiableRange):
+		h.Del("Content-Length")
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	case err != nil:
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(entry.StatusCode)
+		_, err := w.Write(body)
+		return err
+	}
+
+	if len(ranges) == 1 {
+		br := ranges[0]
+		h.Set("Content-Range", br.contentRange(len(body)))
+		h.Set("Content-Length", strconv.Itoa(br.end-br.start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		_, err := w.Write(body[br.start : br.end+1])
+		return err
+	}
+
+	contentType := h.Get("Content-Type")
+	mw := multipart.NewWriter(w)
+	h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusPartialContent)
+
+	for _, br := range ranges {
+		part := textproto.MIMEHeader{"Content-Range": {br.contentRange(len(body))}}
+		if contentType != "" {
+			part.Set("Content-Type", contentType)
+		}
+
+		pw, err := mw.CreatePart(part)
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write(body[br.start : br.end+1]); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// parseRange parses a bytes Range header against a body of size bytes, ranges
+// that cannot be satisfied are left out (RFC 9110 §14.1.2).
+func parseRange(header string, size int) ([]byteRange, error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found {
+		return nil, fmt.Errorf("unsupported range unit in %q", header)
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		first, last, found := strings.Cut(strings.TrimSpace(part), "-")
+		if !found {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+
+		var br byteRange
+		if first == "" {
+			suffix, err := strconv.Atoi(last)
+			if err != nil || suffix < 0 {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			br = byteRange{start: max(size-suffix, 0), end: size - 1}
+		} else {
+			start, err := strconv.Atoi(first)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.Atoi(last); err != nil || end < start {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{start: start, end: min(end, size-1)}
+		}
+
+		ranges = append(ranges, br)
+	}
+
+	if len(ranges) > maxRanges {
+		return nil, fmt.Errorf("too many ranges in %q", header)
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+
+	return ranges, nil
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []byteRange
+		err      bool
+	}{
+		{"bytes=0-9", []byteRange{{0, 9}}, false},
+		{"bytes=5-", []byteRange{{5, 99}}, false},
+		{"bytes=-10", []byteRange{{90, 99}}, false},
+		{"bytes=-500", []byteRange{{0, 99}}, false},
+		{"bytes=90-200", []byteRange{{90, 99}}, false},
+		{"bytes=0-0, 10-19", []byteRange{{0, 0}, {10, 19}}, false},
+		{"bytes=100-, 0-1", []byteRange{{0, 1}}, false},
+		{"bytes=100-200", nil, true},
+		{"bytes=-0", nil, true},
+		{"bytes=9-5", nil, true},
+		{"bytes=a-b", nil, true},
+		{"items=0-9", nil, true},
+	}
+
+	for _, tt := range tests {
+		ranges, err := parseRange(tt.header, 100)
+		if (err != nil) != tt.err || !reflect.DeepEqual(ranges, tt.expected) {
+			t.Errorf("parseRange(%q) = %v, %v, expected %v", tt.header, ranges, err, tt.expected)
+		}
+	}
+}
+
+var rangeEntry = Entry{
+	StatusCode: http.StatusOK,
+	Body:       []byte("0123456789abcdefghij"),
+	Header: http.Header{
+		"Content-Type":   {"text/plain"},
+		"Content-Length": {"20"},
+		"Etag":           {`"v1"`},
+		"Last-Modified":  {"Mon, 02 Jan 2006 15:04:05 GMT"},
+	},
+	StoredAt: time.Now(),
+}
+
+func serveRange(t *testing.T, entry Entry, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	if err := entry.WriteResponse(w, req); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+
+	return w
+}
+
+func TestWriteResponseServesSingleRange(t *testing.T) {
+	w := serveRange(t, rangeEntry, map[string]string{"Range": "bytes=2-5"})
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Fatalf("expected 206 with the requested slice, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Range") != "bytes 2-5/20" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+}
+
+func TestWriteResponseServesMultipleRanges(t *testing.T) {
+	w := serveRange(t, rangeEntry, map[string]string{"Range": "bytes=0-1, -3"})
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d", w.Code)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("expected a multipart/byteranges response, got %q", w.Header().Get("Content-Type"))
+	}
+
+	expected := []struct{ contentRange, body string }{{"bytes 0-1/20", "01"}, {"bytes 17-19/20", "hij"}}
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for _, want := range expected {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("expected part %s: %v", want.contentRange, err)
+		}
+
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Range") != want.contentRange || part.Header.Get("Content-Type") != "text/plain" || string(body) != want.body {
+			t.Errorf("unexpected part %v %q", part.Header, body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, got %v", err)
+	}
+}
+
+func TestWriteResponseRejectsUnsatisfiableRanges(t *testing.T) {
+	w := serveRange(t, rangeEntry, map[string]string{"Range": "bytes=50-"})
+
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */20" {
+		t.Errorf("expected 416, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestWriteResponseEvaluatesIfRange(t *testing.T) {
+	tests := []struct {
+		ifRange string
+		partial bool
+	}{
+		{`"v1"`, true},
+		{`"v0"`, false},
+		{`W/"v1"`, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", true},
+		{"Tue, 03 Jan 2006 15:04:05 GMT", false},
+	}
+
+	for _, tt := range tests {
+		w := serveRange(t, rangeEntry, map[string]string{"Range": "bytes=0-1", "If-Range": tt.ifRange})
+
+		if partial := w.Code == http.StatusPartialContent; partial != tt.partial {
+			t.Errorf("If-Range %s: expected partial %v, got %d", tt.ifRange, tt.partial, w.Code)
+		}
+		if !tt.partial && w.Body.String() != string(rangeEntry.Body) {
+			t.Errorf("If-Range %s: expected the whole body, got %q", tt.ifRange, w.Body.String())
+		}
+	}
+}
+
+func TestWriteResponseIgnoresRangesOfNonSuccessfulEntries(t *testing.T) {
+	entry := rangeEntry
+	entry.StatusCode = http.StatusNotFound
+
+	if w := serveRange(t, entry, map[string]string{"Range": "bytes=0-1"}); w.Code != http.StatusNotFound {
+		t.Errorf("expected the stored status, got %d", w.Code)
+	}
+}
+
+func TestWriteResponseSlicesDecodedBodies(t *testing.T) {
+	c := newCompressingCache(t, EncodingGzip)
+	req := setCompressibleEntry(c, nil, compressibleBody)
+	entry, _ := c.Get(req)
+
+	w := serveRange(t, entry, map[string]string{"Range": "bytes=6-7", "Accept-Encoding": "gzip"})
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(compressibleBody[6:8]) {
+		t.Errorf("expected a slice of the decoded body, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("expected the slice not to be encoded")
+	}
+}
@@ -0,0 +1,541 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRedisPrefix = "cmiyc:"
+
+	redisEntryVersion    = 1
+	defaultRedisTimeout  = 2 * time.Second
+	defaultRedisMaxIdle  = 8
+	defaultRedisMaxOpen  = 64
+	redisScanCount       = "256"
+	redisEntryNamespace  = "e:" // encoded entries, by key
+	redisVaryNamespace   = "v:" // vary header names, by primary key
+	redisKeysNamespace   = "k:" // keys stored for a primary key, its variants included
+	redisTagNamespace    = "t:" // keys tagged with a Surrogate-Key or Cache-Tag value
+	redisPathNamespace   = "p:" // keys stored for a request path
+	redisNamespaceLength = 2
+)
+
+type RedisOptions struct {
+	Address     string
+	Password    string
+	DB          int
+	Prefix      string        // prepended to every key, DefaultRedisPrefix when empty
+	Timeout     time.Duration // per round trip, 2 seconds by default
+	MaxIdle     int           // idle connections kept open, 8 by default
+	MaxOpen     int           // connections in use at once, 64 by default
+	Key         *KeyBuilder
+	Stale       StalePolicy // entries are kept until their stale windows end
+	Compression Encoding
+	OnError     func(error) // receives the errors Get, Set and Delete cannot return
+}
+
+// RedisStore keeps entries in a server speaking RESP, such as Redis or Valkey,
+// shared by several instances. Entries expire on the server once their stale
+// windows are over.
+type RedisStore struct {
+	opts  RedisOptions
+	idle  chan *respConn
+	slots chan struct{} // one per connection in use
+}
+
+var ErrRedisBusy = errors.New("no redis connection available")
+
+func NewRedisStore(opts RedisOptions) *RedisStore {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultRedisPrefix
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultRedisTimeout
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = defaultRedisMaxIdle
+	}
+	if opts.MaxOpen <= 0 {
+		opts.MaxOpen = defaultRedisMaxOpen
+	}
+
+	return &RedisStore{
+		opts:  opts,
+		idle:  make(chan *respConn, opts.MaxIdle),
+		slots: make(chan struct{}, opts.MaxOpen),
+	}
+}
+
+func (s *RedisStore) Get(r *http.Request) (Entry, bool) {
+	primary := s.keyFor(r)
+
+	replies, err := s.do(
+		[]string{"GET", s.name(redisVaryNamespace, primary)},
+		[]string{"GET", s.name(redisEntryNamespace, primary)},
+	)
+	if err != nil {
+		s.fail(err)
+		return Entry{}, false
+	}
+
+	payload := replies[1]
+	if names, varies := replies[0].([]byte); varies {
+		varyHeaders := strings.Split(string(names), ",")
+		key := variantKey(primary, varyHeaders, selectingHeaders(r, varyHeaders))
+
+		if replies, err = s.do([]string{"GET", s.name(redisEntryNamespace, key)}); err != nil {
+			s.fail(err)
+			return Entry{}, false
+		}
+		payload = replies[0]
+	}
+
+	data, found := payload.([]byte)
+	if !found {
+		return Entry{}, false
+	}
+
+	entry, err := decodeRedisEntry(data)
+	if err != nil {
+		s.fail(err)
+		return Entry{}, false
+	}
+	if entry.IsExpired() {
+		return Entry{}, false
+	}
+
+	return entry, true
+}
+
+func (s *RedisStore) Set(r *http.Request, resp *CachableResponse, expiresAt time.Time) {
+	varyHeaders, varyAll := parseVary(resp.Header())
+	if varyAll {
+		return
+	}
+
+	_, entry := NewEntry(r, resp, expiresAt)
+	s.storeEntry(r, varyHeaders, entry)
+}
+
+func (s *RedisStore) storeEntry(r *http.Request, varyHeaders []string, entry Entry) {
+	if err := s.store(s.keyFor(r), varyHeaders, entry.compressedWith(s.opts.Compression)); err != nil {
+		s.fail(err)
+	}
+}
+
+// store writes the entry along with the indexes needed to find and purge it,
+// each of them lives at least as long as the entry.
+func (s *RedisStore) store(primary Key, varyHeaders []string, entry Entry) error {
+	deadline := entry.ExpiresAt.Add(entry.StalePolicy(s.opts.Stale).window())
+	if !deadline.After(time.Now()) {
+		return nil
+	}
+	at := strconv.FormatInt(deadline.UnixMilli(), 10)
+
+	key := variantKey(primary, varyHeaders, entry.Vary)
+	commands := [][]string{{"SET", s.name(redisEntryNamespace, key), encodeRedisEntry(entry), "PXAT", at}}
+
+	if len(varyHeaders) > 0 {
+		varyName := s.name(redisVaryNamespace, primary)
+		commands = append(commands, []string{"SET", varyName, strings.Join(varyHeaders, ","), "KEEPTTL"})
+		commands = append(commands, expireAtLeast(varyName, at)...)
+	} else {
+		commands = append(commands, []string{"DEL", s.name(redisVaryNamespace, primary)})
+	}
+
+	keysName := s.name(redisKeysNamespace, primary)
+	commands = append(commands, []string{"SADD", keysName, key})
+	commands = append(commands, expireAtLeast(keysName, at)...)
+
+	pathName := s.name(redisPathNamespace, entry.Path)
+	commands = append(commands, []string{"SADD", pathName, key})
+	commands = append(commands, expireAtLeast(pathName, at)...)
+
+	for _, tag := range Tags(entry.Header) {
+		tagName := s.name(redisTagNamespace, tag)
+		commands = append(commands, []string{"SADD", tagName, key})
+		commands = append(commands, expireAtLeast(tagName, at)...)
+	}
+
+	_, err := s.do(commands...)
+
+	return err
+}
+
+// expireAtLeast makes name expire at at, unless it is set to expire later.
+func expireAtLeast(name, at string) [][]string {
+	return [][]string{{"PEXPIREAT", name, at, "NX"}, {"PEXPIREAT", name, at, "GT"}}
+}
+
+func (s *RedisStore) Delete(r *http.Request) {
+	if _, err := s.deletePrimary(s.keyFor(r)); err != nil {
+		s.fail(err)
+	}
+}
+
+// deletePrimary deletes the entries stored for a primary key, variants
+// included.
+func (s *RedisStore) deletePrimary(primary Key) (int, error) {
+	keysName := s.name(redisKeysNamespace, primary)
+
+	replies, err := s.do([]string{"SMEMBERS", keysName})
+	if err != nil {
+		return 0, err
+	}
+
+	keys := append(bulkStrings(replies[0]), primary)
+	purged, err := s.deleteEntries(keys, []string{s.name(redisVaryNamespace, primary), keysName})
+
+	return purged, err
+}
+
+// deleteEntries deletes the entries of keys and the indexes named after them,
+// only the deleted entries are counted.
+func (s *RedisStore) deleteEntries(keys []Key, indexes []string) (int, error) {
+	entries := []string{"DEL"}
+	for _, key := range keys {
+		entries = append(entries, s.name(redisEntryNamespace, key))
+	}
+
+	commands := [][]string{entries}
+	if len(indexes) > 0 {
+		commands = append(commands, append([]string{"DEL"}, indexes...))
+	}
+
+	replies, err := s.do(commands...)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, _ := replies[0].(int64)
+
+	return int(deleted), nil
+}
+
+func (s *RedisStore) Purge(selector PurgeSelector) (int, error) {
+	if selector.IsZero() {
+		return 0, ErrEmptyPurgeSelector
+	}
+
+	purged := 0
+
+	if selector.Key != "" {
+		n, err := s.deletePrimary(selector.Key)
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+	}
+
+	for _, tag := range selector.Tags {
+		tagName := s.name(redisTagNamespace, tag)
+
+		replies, err := s.do([]string{"SMEMBERS", tagName})
+		if err != nil {
+			return purged, err
+		}
+
+		n, err := s.deleteEntries(bulkStrings(replies[0]), []string{tagName})
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+	}
+
+	if selector.Prefix == "" && selector.Glob == "" {
+		return purged, nil
+	}
+
+	n, err := s.purgeMatching(selector)
+
+	return purged + n, err
+}
+
+// purgeMatching matches globs against the primary keys of every stored
+// entry, and prefixes against the path indexes rather than the entries.
+func (s *RedisStore) purgeMatching(selector PurgeSelector) (int, error) {
+	purged := 0
+
+	if selector.Glob != "" {
+		glob, err := compileGlob(selector.Glob)
+		if err != nil {
+			return 0, err
+		}
+
+		err = s.scan(redisEntryNamespace, "", func(keys []Key) error {
+			var matching []Key
+			for _, key := range keys {
+				if primary, _, _ := strings.Cut(key, "\x00"); glob.MatchString(primary) {
+					matching = append(matching, key)
+				}
+			}
+
+			if len(matching) == 0 {
+				return nil
+			}
+
+			n, err := s.deleteEntries(matching, nil)
+			purged += n
+
+			return err
+		})
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	if selector.Prefix == "" {
+		return purged, nil
+	}
+
+	err := s.scan(redisPathNamespace, selector.Prefix, func(paths []string) error {
+		commands := make([][]string, len(paths))
+		indexes := make([]string, len(paths))
+		for i, path := range paths {
+			indexes[i] = s.name(redisPathNamespace, path)
+			commands[i] = []string{"SMEMBERS", indexes[i]}
+		}
+
+		replies, err := s.do(commands...)
+		if err != nil {
+			return err
+		}
+
+		var keys []Key
+		for _, reply := range replies {
+			keys = append(keys, bulkStrings(reply)...)
+		}
+
+		n, err := s.deleteEntries(keys, indexes)
+		purged += n
+
+		return err
+	})
+
+	return purged, err
+}
+
+// Stats only counts the entries, sizes are left to the server.
+func (s *RedisStore) Stats() Stats {
+	entries := 0
+	if err := s.scan(redisEntryNamespace, "", func(keys []Key) error {
+		entries += len(keys)
+		return nil
+	}); err != nil {
+		s.fail(err)
+	}
+
+	return Stats{Entries: entries}
+}
+
+// scan calls fn with the names starting with prefix in the namespace, without
+// the namespace, a page at a time.
+func (s *RedisStore) scan(namespace, prefix string, fn func(names []string) error) error {
+	pattern := escapeRedisGlob(s.name(namespace, prefix)) + "*"
+	cursor := "0"
+
+	for {
+		replies, err := s.do([]string{"SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount})
+		if err != nil {
+			return err
+		}
+
+		page, ok := replies[0].([]any)
+		if !ok || len(page) != 2 {
+			return errors.New("malformed SCAN reply")
+		}
+
+		next, _ := page[0].([]byte)
+		names := bulkStrings(page[1])
+		for i, name := range names {
+			names[i] = name[len(s.opts.Prefix)+redisNamespaceLength:]
+		}
+
+		if len(names) > 0 {
+			if err := fn(names); err != nil {
+				return err
+			}
+		}
+
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+func (s *RedisStore) Close() error {
+	for {
+		select {
+		case conn := <-s.idle:
+			_ = conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do runs the commands in a single round trip, on an idle connection when
+// there is one. The first error reply is returned along with the replies.
+func (s *RedisStore) do(commands ...[]string) ([]any, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer func() { <-s.slots }()
+
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := conn.do(s.opts.Timeout, commands...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	select {
+	case s.idle <- conn:
+	default:
+		_ = conn.Close()
+	}
+
+	for _, reply := range replies {
+		if err, failed := reply.(respError); failed {
+			return replies, err
+		}
+	}
+
+	return replies, nil
+}
+
+// acquire waits up to the round trip timeout for a connection to be released
+// once MaxOpen of them are in use.
+func (s *RedisStore) acquire() error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(s.opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("redis %s: %w", s.opts.Address, ErrRedisBusy)
+	}
+}
+
+func (s *RedisStore) conn() (*respConn, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+
+	conn, err := dialRESP(s.opts.Address, s.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var setup [][]string
+	if s.opts.Password != "" {
+		setup = append(setup, []string{"AUTH", s.opts.Password})
+	}
+	if s.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.opts.DB)})
+	}
+	if len(setup) == 0 {
+		return conn, nil
+	}
+
+	replies, err := conn.do(s.opts.Timeout, setup...)
+	if err == nil {
+		for _, reply := range replies {
+			if replyErr, failed := reply.(respError); failed {
+				err = replyErr
+			}
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("redis %s: %w", s.opts.Address, err)
+	}
+
+	return conn, nil
+}
+
+func (s *RedisStore) keyFor(r *http.Request) Key {
+	if s.opts.Key != nil {
+		return s.opts.Key.Key(r)
+	}
+
+	return KeyFrom(r)
+}
+
+func (s *RedisStore) name(namespace, key string) string {
+	return s.opts.Prefix + namespace + key
+}
+
+func (s *RedisStore) fail(err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(err)
+	}
+}
+
+func encodeRedisEntry(entry Entry) string {
+	var buf bytes.Buffer
+	buf.WriteByte(redisEntryVersion)
+	encodeEntry(&buf, entry)
+
+	return buf.String()
+}
+
+func decodeRedisEntry(data []byte) (Entry, error) {
+	d := &decoder{data: data}
+	if version := d.byte(); version != redisEntryVersion {
+		return Entry{}, fmt.Errorf("unsupported redis entry version %d", version)
+	}
+
+	entry := decodeEntry(d)
+	if d.err != nil {
+		return Entry{}, fmt.Errorf("corrupted redis entry: %w", d.err)
+	}
+
+	return entry, nil
+}
+
+func bulkStrings(reply any) []string {
+	array, _ := reply.([]any)
+
+	values := make([]string, 0, len(array))
+	for _, value := range array {
+		if b, ok := value.([]byte); ok {
+			values = append(values, string(b))
+		}
+	}
+
+	return values
+}
+
+// escapeRedisGlob escapes the characters MATCH patterns give a meaning to.
+func escapeRedisGlob(s string) string {
+	var escaped strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(c)
+	}
+
+	return escaped.String()
+}
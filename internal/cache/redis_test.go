@@ -0,0 +1,530 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRESPServer implements the commands RedisStore relies on, with their
+// Redis semantics.
+type fakeRESPServer struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	values   map[string]string
+	sets     map[string]map[string]struct{}
+	expires  map[string]time.Time
+	commands map[string]int // executed, by command
+}
+
+func newFakeRESPServer(t *testing.T, password string) *fakeRESPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := &fakeRESPServer{
+		listener: listener,
+		password: password,
+		values:   make(map[string]string),
+		sets:     make(map[string]map[string]struct{}),
+		expires:  make(map[string]time.Time),
+		commands: make(map[string]int),
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (f *fakeRESPServer) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRESPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authenticated := f.password == ""
+
+	for {
+		request, err := readRESPReply(r)
+		if err != nil {
+			return
+		}
+
+		args := bulkStrings(request)
+		if len(args) == 0 {
+			return
+		}
+
+		command := strings.ToUpper(args[0])
+		switch {
+		case command == "AUTH":
+			if len(args) == 2 && args[1] == f.password {
+				authenticated = true
+				w.WriteString("+OK\r\n")
+			} else {
+				w.WriteString("-WRONGPASS invalid password\r\n")
+			}
+		case !authenticated:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		default:
+			f.mu.Lock()
+			f.execute(w, command, args[1:])
+			f.mu.Unlock()
+		}
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRESPServer) execute(w *bufio.Writer, command string, args []string) {
+	f.commands[command]++
+
+	switch command {
+	case "SELECT":
+		w.WriteString("+OK\r\n")
+	case "GET":
+		f.expire(args[0])
+		if value, exists := f.values[args[0]]; exists {
+			writeBulk(w, value)
+		} else {
+			w.WriteString("$-1\r\n")
+		}
+	case "SET":
+		key := args[0]
+		f.values[key] = args[1]
+		delete(f.sets, key)
+		if strings.ToUpper(args[len(args)-1]) != "KEEPTTL" {
+			delete(f.expires, key)
+		}
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PXAT" {
+			ms, _ := strconv.ParseInt(args[3], 10, 64)
+			f.expires[key] = time.UnixMilli(ms)
+		}
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		deleted := 0
+		for _, key := range args {
+			f.expire(key)
+			if f.exists(key) {
+				deleted++
+			}
+			f.remove(key)
+		}
+		fmt.Fprintf(w, ":%d\r\n", deleted)
+	case "SADD":
+		f.expire(args[0])
+		members, exists := f.sets[args[0]]
+		if !exists {
+			members = make(map[string]struct{})
+			f.sets[args[0]] = members
+		}
+		added := 0
+		for _, member := range args[1:] {
+			if _, exists := members[member]; !exists {
+				members[member] = struct{}{}
+				added++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", added)
+	case "SMEMBERS":
+		f.expire(args[0])
+		fmt.Fprintf(w, "*%d\r\n", len(f.sets[args[0]]))
+		for member := range f.sets[args[0]] {
+			writeBulk(w, member)
+		}
+	case "PEXPIREAT":
+		key := args[0]
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		at := time.UnixMilli(ms)
+		current, volatile := f.expires[key]
+
+		set := f.exists(key)
+		if len(args) == 3 {
+			switch strings.ToUpper(args[2]) {
+			case "NX":
+				set = set && !volatile
+			case "GT":
+				set = set && volatile && at.After(current)
+			}
+		}
+		if set {
+			f.expires[key] = at
+			w.WriteString(":1\r\n")
+		} else {
+			w.WriteString(":0\r\n")
+		}
+	case "SCAN":
+		pattern, _ := compileGlob(strings.ReplaceAll(args[2], `\`, ""))
+		var keys []string
+		for _, key := range append(slices.Collect(maps.Keys(f.values)), slices.Collect(maps.Keys(f.sets))...) {
+			f.expire(key)
+			if f.exists(key) && pattern.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		fmt.Fprintf(w, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			writeBulk(w, key)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", command)
+	}
+}
+
+func (f *fakeRESPServer) exists(key string) bool {
+	_, isValue := f.values[key]
+	_, isSet := f.sets[key]
+	return isValue || isSet
+}
+
+func (f *fakeRESPServer) expire(key string) {
+	if at, volatile := f.expires[key]; volatile && !time.Now().Before(at) {
+		f.remove(key)
+	}
+}
+
+func (f *fakeRESPServer) remove(key string) {
+	delete(f.values, key)
+	delete(f.sets, key)
+	delete(f.expires, key)
+}
+
+func (f *fakeRESPServer) executed(command string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.commands[command]
+}
+
+func (f *fakeRESPServer) expiresAt(key string) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.expires[key]
+}
+
+func writeBulk(w *bufio.Writer, value string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+}
+
+func newTestRedisStore(t *testing.T, server *fakeRESPServer, opts RedisOptions) *RedisStore {
+	opts.Address = server.addr()
+	store := NewRedisStore(opts)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func setStoreEntry(store Store, target string, header http.Header, body string, expiresAt time.Time) *http.Request {
+	resp := NewCachableResponse(httptest.NewRecorder())
+	for name, values := range header {
+		resp.Header()[name] = values
+	}
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte(body))
+
+	req := httptest.NewRequest("GET", target, nil)
+	store.Set(req, resp, expiresAt)
+
+	return req
+}
+
+func TestRedisStoreRoundTrip(t *testing.T) {
+	store := newTestRedisStore(t, newFakeRESPServer(t, ""), RedisOptions{})
+	expiresAt := time.Now().Add(time.Minute)
+	req := setStoreEntry(store, "http://example.com/resource", http.Header{"Etag": {`"v1"`}, "Surrogate-Key": {"a b"}}, "shared", expiresAt)
+
+	entry, found := store.Get(req)
+	if !found {
+		t.Fatal("expected entry to be found")
+	}
+	if string(entry.Body) != "shared" || entry.Path != "/resource" || entry.StatusCode != http.StatusOK ||
+		entry.Header.Get("ETag") != `"v1"` || !entry.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if _, found := store.Get(httptest.NewRequest("GET", "http://example.com/missing", nil)); found {
+		t.Error("expected a missing entry not to be found")
+	}
+	if entries := store.Stats().Entries; entries != 1 {
+		t.Errorf("expected 1 entry, got %d", entries)
+	}
+}
+
+func TestRedisStoreExpiresEntriesAfterTheirStaleWindows(t *testing.T) {
+	server := newFakeRESPServer(t, "")
+	store := newTestRedisStore(t, server, RedisOptions{Stale: StalePolicy{IfError: time.Hour}})
+
+	expiresAt := time.Now().Add(time.Minute)
+	setStoreEntry(store, "http://example.com/resource", nil, "body", expiresAt)
+
+	name := DefaultRedisPrefix + redisEntryNamespace + "http://example.com/resource"
+	if got := server.expiresAt(name); got.UnixMilli() != expiresAt.Add(time.Hour).UnixMilli() {
+		t.Errorf("expected the entry to expire after its stale window, got %v", got)
+	}
+
+	expired := setStoreEntry(store, "http://example.com/expired", http.Header{"Cache-Control": {"stale-if-error=0"}}, "body", time.Now().Add(-time.Minute))
+	if _, found := store.Get(expired); found {
+		t.Error("expected an expired entry not to be found")
+	}
+	if entries := store.Stats().Entries; entries != 1 {
+		t.Errorf("expected entries past their stale windows not to be stored, got %d", entries)
+	}
+}
+
+func TestRedisStoreVariants(t *testing.T) {
+	store := newTestRedisStore(t, newFakeRESPServer(t, ""), RedisOptions{})
+	vary := http.Header{"Vary": {"Accept-Language"}}
+
+	for _, language := range []string{"en", "fr"} {
+		resp := NewCachableResponse(httptest.NewRecorder())
+		resp.Header()["Vary"] = vary["Vary"]
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte(language))
+
+		req := httptest.NewRequest("GET", "http://example.com/greeting", nil)
+		req.Header.Set("Accept-Language", language)
+		store.Set(req, resp, time.Now().Add(time.Minute))
+	}
+
+	for _, language := range []string{"en", "fr"} {
+		req := httptest.NewRequest("GET", "http://example.com/greeting", nil)
+		req.Header.Set("Accept-Language", language)
+
+		if entry, found := store.Get(req); !found || string(entry.Body) != language {
+			t.Errorf("expected the %s variant, got %q", language, entry.Body)
+		}
+	}
+
+	other := httptest.NewRequest("GET", "http://example.com/greeting", nil)
+	other.Header.Set("Accept-Language", "de")
+	if _, found := store.Get(other); found {
+		t.Error("expected no variant for another language")
+	}
+
+	store.Delete(other)
+	if entries := store.Stats().Entries; entries != 0 {
+		t.Errorf("expected Delete to remove every variant, %d left", entries)
+	}
+}
+
+func TestRedisStorePurge(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector PurgeSelector
+		purged   []string
+	}{
+		{"key", PurgeSelector{Key: "http://example.com/a/1"}, []string{"/a/1"}},
+		{"tag", PurgeSelector{Tags: []string{"even"}}, []string{"/a/2", "/b/2"}},
+		{"prefix", PurgeSelector{Prefix: "/b/"}, []string{"/b/1", "/b/2"}},
+		{"glob", PurgeSelector{Glob: "http://example.com/*/1"}, []string{"/a/1", "/b/1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeRESPServer(t, "")
+			store := newTestRedisStore(t, server, RedisOptions{})
+
+			requests := make(map[string]*http.Request)
+			for _, path := range []string{"/a/1", "/a/2", "/b/1", "/b/2"} {
+				var header http.Header
+				if strings.HasSuffix(path, "2") {
+					header = http.Header{"Surrogate-Key": {"even"}}
+				}
+				requests[path] = setStoreEntry(store, "http://example.com"+path, header, path, time.Now().Add(time.Minute))
+			}
+
+			gets := server.executed("GET")
+			purged, err := store.Purge(tt.selector)
+			if err != nil || purged != len(tt.purged) {
+				t.Fatalf("expected %d entries purged, got %d, %v", len(tt.purged), purged, err)
+			}
+			if n := server.executed("GET") - gets; n != 0 {
+				t.Errorf("expected entries to be matched without reading them, %d read", n)
+			}
+
+			var left []string
+			for path, req := range requests {
+				if _, found := store.Get(req); !found {
+					left = append(left, path)
+				}
+			}
+			slices.Sort(left)
+			if !slices.Equal(left, tt.purged) {
+				t.Errorf("expected %v to be purged, got %v", tt.purged, left)
+			}
+		})
+	}
+
+	store := newTestRedisStore(t, newFakeRESPServer(t, ""), RedisOptions{})
+	if _, err := store.Purge(PurgeSelector{}); !errors.Is(err, ErrEmptyPurgeSelector) {
+		t.Errorf("expected ErrEmptyPurgeSelector, got %v", err)
+	}
+}
+
+func TestRedisStoreReportsErrors(t *testing.T) {
+	var mu sync.Mutex
+	var errs []error
+	onError := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	store := newTestRedisStore(t, newFakeRESPServer(t, "secret"), RedisOptions{Password: "wrong", OnError: onError})
+	req := setStoreEntry(store, "http://example.com/resource", nil, "body", time.Now().Add(time.Minute))
+	if _, found := store.Get(req); found {
+		t.Error("expected nothing to be stored without authentication")
+	}
+
+	authenticated := newTestRedisStore(t, newFakeRESPServer(t, "secret"), RedisOptions{Password: "secret", DB: 2, OnError: onError})
+	req = setStoreEntry(authenticated, "http://example.com/resource", nil, "body", time.Now().Add(time.Minute))
+	if _, found := authenticated.Get(req); !found {
+		t.Error("expected entry to be stored once authenticated")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 2 {
+		t.Errorf("expected the failed Set and Get to be reported, got %v", errs)
+	}
+}
+
+func TestRedisStoreBoundsOpenConnections(t *testing.T) {
+	store := newTestRedisStore(t, newFakeRESPServer(t, ""), RedisOptions{MaxOpen: 1, Timeout: 50 * time.Millisecond})
+	get := []string{"GET", "missing"}
+
+	store.slots <- struct{}{}
+	start := time.Now()
+	if _, err := store.do(get); !errors.Is(err, ErrRedisBusy) {
+		t.Errorf("expected ErrRedisBusy while every connection is in use, got %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("expected to wait for a connection up to the timeout, waited %v", waited)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-store.slots
+	}()
+	if _, err := store.do(get); err != nil {
+		t.Errorf("expected a released connection to be used, got %v", err)
+	}
+}
+
+func TestRedisStoreKeepsCompressedEntries(t *testing.T) {
+	store := newTestRedisStore(t, newFakeRESPServer(t, ""), RedisOptions{Compression: EncodingGzip})
+	req := setStoreEntry(store, "http://example.com/compressed", nil, string(compressibleBody), time.Now().Add(time.Minute))
+
+	entry, found := store.Get(req)
+	if !found || entry.Encoding != EncodingGzip {
+		t.Fatalf("expected a gzip encoded entry, got %q", entry.Encoding)
+	}
+
+	w := httptest.NewRecorder()
+	if err := entry.WriteResponse(w, req); err != nil || w.Body.String() != string(compressibleBody) {
+		t.Errorf("expected the entry to be decompressed, got error %v", err)
+	}
+}
+
+func TestHttpCacheSharesEntriesThroughTheStore(t *testing.T) {
+	server := newFakeRESPServer(t, "")
+
+	caches := make([]*HttpCache, 2)
+	for i := range caches {
+		caches[i] = NewEmptyCacheWithOptions(1, 1, Options{Shared: NewRedisStore(RedisOptions{Address: server.addr()})})
+		t.Cleanup(caches[i].Cleanup)
+	}
+
+	req := setStoreEntry(caches[0], "http://example.com/resource", nil, "shared", time.Now().Add(time.Minute))
+
+	entry, found := caches[1].Get(req)
+	if !found || string(entry.Body) != "shared" {
+		t.Fatal("expected the entry to be found through the shared store")
+	}
+	if entries := caches[1].Stats().Entries; entries != 1 {
+		t.Errorf("expected the shared entry to be kept locally, got %d entries", entries)
+	}
+
+	caches[1].Delete(req)
+	if _, found := caches[0].shared.Get(req); found {
+		t.Error("expected Delete to reach the shared store")
+	}
+
+	setStoreEntry(caches[0], "http://example.com/tagged", http.Header{"Surrogate-Key": {"tag"}}, "tagged", time.Now().Add(time.Minute))
+	if purged, err := caches[0].Purge(PurgeSelector{Tags: []string{"tag"}}); err != nil || purged != 2 {
+		t.Errorf("expected the local and shared copies to be purged, got %d, %v", purged, err)
+	}
+}
+
+func TestHttpCacheHandsItsEntriesToTheStore(t *testing.T) {
+	server := newFakeRESPServer(t, "")
+	c := NewEmptyCacheWithOptions(1, 1, Options{Shared: NewRedisStore(RedisOptions{Address: server.addr()})})
+	t.Cleanup(c.Cleanup)
+
+	req := setStoreEntry(c, "http://example.com/resource", http.Header{"Etag": {`"v1"`}}, "body", time.Now().Add(time.Minute))
+
+	local := c.shardFor(c.KeyFor(req)).entries[c.KeyFor(req)]
+	shared, found := c.shared.Get(req)
+	if !found || !shared.StoredAt.Equal(local.StoredAt) {
+		t.Fatalf("expected the stored entry to be shared as is, got %v and %v", local.StoredAt, shared.StoredAt)
+	}
+
+	revalidated := shared.Revalidated(http.Header{"Etag": {`"v1"`}, "X-Revalidated": {"yes"}})
+	revalidated.ExpiresAt = time.Now().Add(time.Hour)
+	if !c.Refresh(req, revalidated) {
+		t.Fatal("expected the entry to be refreshed")
+	}
+
+	shared, found = c.shared.Get(req)
+	if !found || shared.Header.Get("X-Revalidated") != "yes" || !shared.ExpiresAt.Equal(revalidated.ExpiresAt) {
+		t.Errorf("expected the shared copy to be refreshed, got %+v", shared)
+	}
+	if string(shared.Body) != "body" {
+		t.Errorf("expected the shared body to be kept, got %q", shared.Body)
+	}
+}
+
+func TestRESPReplies(t *testing.T) {
+	input := "+OK\r\n-ERR failed\r\n:42\r\n$5\r\nhello\r\n$-1\r\n*2\r\n$1\r\na\r\n:1\r\n"
+	expected := []any{"OK", respError("ERR failed"), int64(42), []byte("hello"), nil, []any{[]byte("a"), int64(1)}}
+
+	r := bufio.NewReader(strings.NewReader(input))
+	for _, want := range expected {
+		reply, err := readRESPReply(r)
+		if err != nil || !reflect.DeepEqual(reply, want) {
+			t.Errorf("expected %#v, got %#v, %v", want, reply, err)
+		}
+	}
+
+	if _, err := readRESPReply(bufio.NewReader(strings.NewReader("?\r\n"))); err == nil {
+		t.Error("expected an unknown reply type to be rejected")
+	}
+}
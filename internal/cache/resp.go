@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// maxRESPBulkSize is the largest bulk string read from a server, the default
+// proto-max-bulk-len of Redis.
+const maxRESPBulkSize = 512 << 20
+
+// respError is an error reply of the server, the connection stays usable.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respConn is a connection speaking RESP2. Replies are decoded as string
+// (simple strings), respError, int64, []byte (bulk strings), nil and []any.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func dialRESP(address string, timeout time.Duration) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// do sends the commands in a single pipeline and returns their replies.
+func (c *respConn) do(timeout time.Duration, commands ...[]string) ([]any, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	for _, command := range commands {
+		writeRESPCommand(c.w, command)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, len(commands))
+	for i := range replies {
+		reply, err := readRESPReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
+
+func writeRESPCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+func readRESPReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed RESP reply")
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return respError(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil || size > maxRESPBulkSize {
+			return nil, fmt.Errorf("invalid RESP bulk size %q", value)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(r, bulk); err != nil {
+			return nil, err
+		}
+		return bulk[:size], nil
+	case '*':
+		count, err := strconv.Atoi(value)
+		if err != nil || count > maxRESPBulkSize {
+			return nil, fmt.Errorf("invalid RESP array size %q", value)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+
+		array := make([]any, count)
+		for i := range array {
+			if array[i], err = readRESPReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("unknown RESP reply type %q", kind)
+	}
+}
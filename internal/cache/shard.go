@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"hash/maphash"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultShards = 16
+
+	// sweepBatchSize bounds how many expired entries a sweep deletes under a
+	// single acquisition of a shard write lock.
+	sweepBatchSize = 64
+)
+
+// shard holds the entries whose primary key hashes to it, along with their
+// variant and tag indexes, so that variants of a resource always share a
+// shard. Each shard has its own eviction policy and size, the cache size is
+// the sum of the shard sizes.
+type shard struct {
+	index     int
+	entries   map[Key]Entry
+	size      int // in bytes
+	variants  map[Key]*variantIndex
+	primaryOf map[Key]Key
+	tags      map[string]map[Key]struct{}
+	policy    EvictionPolicy
+	hits      chan Key
+	orphans   map[Key]Entry // entries the disk tier evicted while the shard was locked
+	sync.RWMutex
+}
+
+func newShard(index int, policy EvictionPolicy) *shard {
+	return &shard{
+		index:     index,
+		entries:   make(map[Key]Entry),
+		variants:  make(map[Key]*variantIndex),
+		primaryOf: make(map[Key]Key),
+		tags:      make(map[string]map[Key]struct{}),
+		policy:    policy,
+		hits:      make(chan Key, hitBufferSize),
+	}
+}
+
+// shardFor returns the shard of a key, variant keys extend their primary key
+// after a NUL byte and land in the shard of their primary key.
+func (c *HttpCache) shardFor(key Key) *shard {
+	primary, _, _ := strings.Cut(key, "\x00")
+
+	return c.shards[maphash.String(c.seed, primary)%uint64(len(c.shards))]
+}
+
+// update runs fn under the shard write lock, entries the disk tier evicted
+// meanwhile are then forgotten from their own shards.
+func (c *HttpCache) update(s *shard, fn func()) {
+	s.Lock()
+	fn()
+	orphans := s.orphans
+	s.orphans = nil
+	s.Unlock()
+
+	for key, meta := range orphans {
+		owner := c.shardFor(key)
+
+		owner.Lock()
+		if _, inMemory := owner.entries[key]; !inMemory && !c.onDisk(key) {
+			owner.untagLocked(key, meta)
+			owner.forgetVariantLocked(key)
+		}
+		owner.Unlock()
+	}
+}
+
+// reclaim evicts entries from the other shards while the cache exceeds
+// MaxSize, a shard storing an entry only evicts its own entries.
+func (c *HttpCache) reclaim(from *shard) {
+	for c.overBudget() {
+		evicted := false
+
+		for i := 1; i < len(c.shards) && c.overBudget(); i++ {
+			s := c.shards[(from.index+i)%len(c.shards)]
+			c.update(s, func() {
+				s.applyHits()
+				if victim, found := s.policy.Evict("", 0); found && victim != "" {
+					c.evictLocked(s, victim)
+					c.evictions.Add(1)
+					evicted = true
+				}
+			})
+		}
+
+		if !evicted {
+			return
+		}
+	}
+}
+
+func (c *HttpCache) overBudget() bool {
+	return c.size.Load() > int64(c.MaxSize)
+}
+
+// sweep deletes the unusable entries of a shard. Entries are checked under
+// the read lock and deleted in batches, so requests are never stalled for a
+// whole scan.
+func (c *HttpCache) sweep(s *shard) {
+	// Hits of read only traffic are applied here, without waiting for a write.
+	if len(s.hits) > 0 {
+		c.update(s, s.applyHits)
+	}
+
+	s.RLock()
+	var unusable []Key
+	for key, entry := range s.entries {
+		if !c.isUsable(&entry) {
+			unusable = append(unusable, key)
+		}
+	}
+	s.RUnlock()
+
+	c.deleteUnusable(s, unusable)
+}
+
+func (c *HttpCache) sweepDisk() {
+	unusable := make(map[*shard][]Key)
+	c.eachOnDisk(func(key Key, meta Entry) {
+		if !c.isUsable(&meta) {
+			s := c.shardFor(key)
+			unusable[s] = append(unusable[s], key)
+		}
+	})
+
+	for s, keys := range unusable {
+		c.deleteUnusable(s, keys)
+	}
+}
+
+func (c *HttpCache) deleteUnusable(s *shard, keys []Key) {
+	for len(keys) > 0 {
+		batch := keys[:min(sweepBatchSize, len(keys))]
+		keys = keys[len(batch):]
+
+		c.update(s, func() {
+			for _, key := range batch {
+				if entry, found := c.peekLocked(s, key); found && !c.isUsable(&entry) {
+					c.deleteLocked(s, key)
+				}
+			}
+		})
+	}
+}
+
+// recordHit buffers a hit for the eviction policy. A full buffer is drained
+// when the write lock is free, reads never wait for it.
+func (s *shard) recordHit(key Key) {
+	select {
+	case s.hits <- key:
+		return
+	default:
+	}
+
+	if s.TryLock() {
+		s.applyHits()
+		s.policy.Touch(key)
+		s.Unlock()
+	}
+}
+
+func (s *shard) applyHits() {
+	for {
+		select {
+		case key := <-s.hits:
+			s.policy.Touch(key)
+		default:
+			return
+		}
+	}
+}
+
+func (s *shard) indexVariantLocked(primary Key, varyHeaders []string, key Key) {
+	if len(varyHeaders) == 0 {
+		return
+	}
+
+	index, exists := s.variants[primary]
+	if !exists {
+		index = &variantIndex{headers: varyHeaders, keys: make(map[Key]struct{})}
+		s.variants[primary] = index
+	}
+	index.keys[key] = struct{}{}
+	s.primaryOf[key] = primary
+}
+
+func (s *shard) forgetVariantLocked(key Key) {
+	if primary, exists := s.primaryOf[key]; exists {
+		delete(s.primaryOf, key)
+		if index := s.variants[primary]; index != nil {
+			delete(index.keys, key)
+			if len(index.keys) == 0 {
+				delete(s.variants, primary)
+			}
+		}
+	}
+}
+
+func (s *shard) primaryLocked(key Key) Key {
+	if primary, exists := s.primaryOf[key]; exists {
+		return primary
+	}
+
+	return key
+}
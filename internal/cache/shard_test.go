@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVariantsShareTheirPrimaryShard(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	for i := 0; i < 100; i++ {
+		primary := fmt.Sprintf("http://example.com/%d", i)
+		variant := variantKey(primary, []string{"Accept-Encoding"}, http.Header{"Accept-Encoding": {"gzip"}})
+
+		if c.shardFor(primary) != c.shardFor(variant) {
+			t.Fatalf("expected %q and its variant to share a shard", primary)
+		}
+	}
+}
+
+func TestShardsEnforceTheGlobalMaxSize(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	c.MaxSize = 1000
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			rng := rand.New(rand.NewSource(int64(worker)))
+			for i := 0; i < 500; i++ {
+				storeKey(c, fmt.Sprintf("key-%d", rng.Intn(200)), 1+rng.Intn(50))
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if c.CurrentSize() > c.MaxSize {
+		t.Errorf("cache size %d exceeds max size %d", c.CurrentSize(), c.MaxSize)
+	}
+
+	total, used := 0, 0
+	for _, s := range c.shards {
+		shardTotal := 0
+		for _, entry := range s.entries {
+			shardTotal += len(entry.Body)
+		}
+		if shardTotal != s.size {
+			t.Errorf("shard %d accounting drifted: entries hold %d bytes, size is %d", s.index, shardTotal, s.size)
+		}
+		if len(s.entries) > 0 {
+			used++
+		}
+		total += shardTotal
+	}
+
+	if total != c.CurrentSize() {
+		t.Errorf("size accounting drifted: shards hold %d bytes, CurrentSize is %d", total, c.CurrentSize())
+	}
+	if used < 2 {
+		t.Errorf("expected entries to be spread over shards, %d used", used)
+	}
+}
+
+func TestSweepDeletesUnusableEntries(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	var stale, fresh []*http.Request
+	for i := 0; i < 50; i++ {
+		stale = append(stale, setStaleTestEntry(c, fmt.Sprintf("stale-%d", i), nil))
+		fresh = append(fresh, setTestEntry(c, fmt.Sprintf("fresh-%d", i), []byte("fresh")))
+	}
+
+	for _, s := range c.shards {
+		c.sweep(s)
+	}
+
+	if entries := c.Stats().Entries; entries != len(fresh) {
+		t.Errorf("expected %d entries left, got %d", len(fresh), entries)
+	}
+	for _, r := range stale {
+		if _, exists := storedEntry(c, c.KeyFor(r)); exists {
+			t.Errorf("expected %s to be swept", r.URL.Path)
+		}
+	}
+}
+
+func TestSweepDeletesUnusableDiskEntries(t *testing.T) {
+	c := newTieredCache(t, t.TempDir(), 5, 1000)
+
+	r := setStaleTestEntry(c, "stale", nil)
+	if c.Stats().DiskEntries != 1 {
+		t.Fatal("expected stale entry to be stored on disk")
+	}
+
+	c.sweepDisk()
+
+	if c.Stats().DiskEntries != 0 {
+		t.Error("expected stale disk entry to be swept")
+	}
+	if _, found := c.Lookup(r); found {
+		t.Error("expected swept entry to be gone")
+	}
+}
+
+func benchmarkShards(b *testing.B, run func(b *testing.B, c *HttpCache, keys []Key)) {
+	keys := make([]Key, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("http://example.com/item/%d", i)
+	}
+
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := NewEmptyCacheWithOptions(64, 1, Options{Shards: shards})
+			defer c.Cleanup()
+
+			for _, key := range keys {
+				storeKey(c, key, 512)
+			}
+
+			b.ResetTimer()
+			run(b, c, keys)
+		})
+	}
+}
+
+func BenchmarkParallelGet(b *testing.B) {
+	benchmarkShards(b, func(b *testing.B, c *HttpCache, keys []Key) {
+		b.RunParallel(func(pb *testing.PB) {
+			rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+			for pb.Next() {
+				c.getEntry(keys[rng.Intn(len(keys))])
+			}
+		})
+	})
+}
+
+// BenchmarkParallelMixed stores one entry every ten requests.
+func BenchmarkParallelMixed(b *testing.B) {
+	benchmarkShards(b, func(b *testing.B, c *HttpCache, keys []Key) {
+		b.RunParallel(func(pb *testing.PB) {
+			rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+			for i := 0; pb.Next(); i++ {
+				key := keys[rng.Intn(len(keys))]
+				if i%10 == 0 {
+					storeKey(c, key, 512)
+				} else {
+					c.getEntry(key)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkParallelGetDuringSweeps reads while every shard is being swept.
+func BenchmarkParallelGetDuringSweeps(b *testing.B) {
+	benchmarkShards(b, func(b *testing.B, c *HttpCache, keys []Key) {
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					for _, s := range c.shards {
+						c.sweep(s)
+					}
+				}
+			}
+		}()
+
+		b.RunParallel(func(pb *testing.PB) {
+			rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+			for pb.Next() {
+				c.getEntry(keys[rng.Intn(len(keys))])
+			}
+		})
+	})
+}
+
+func TestReadOnlyTrafficKeepsRecency(t *testing.T) {
+	c := newPolicyCache(t, EvictionLRU, 10)
+	for i := 0; i < 10; i++ {
+		storeKey(c, fmt.Sprintf("key-%d", i), 1)
+	}
+
+	// More hits than the buffer holds, key-0 is read last.
+	for i := 0; i < 2*hitBufferSize; i++ {
+		c.getEntry(fmt.Sprintf("key-%d", 1+i%9))
+	}
+	c.getEntry("key-0")
+
+	storeKey(c, "key-10", 1)
+	if _, found := c.getEntry("key-0"); !found {
+		t.Error("expected the most recently read entry to survive the eviction")
+	}
+}
+
+func TestSweepAppliesBufferedHits(t *testing.T) {
+	c := newPolicyCache(t, EvictionLRU, 10)
+	for i := 0; i < 10; i++ {
+		storeKey(c, fmt.Sprintf("key-%d", i), 1)
+	}
+
+	c.getEntry("key-0")
+	c.sweep(c.shards[0])
+	if n := len(c.shards[0].hits); n != 0 {
+		t.Errorf("expected the sweep to apply the buffered hits, %d left", n)
+	}
+}
@@ -0,0 +1,426 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// A snapshot starts with snapshotMagic and the format version, followed by
+// one record per entry and an end record holding the number of entries. Every
+// record is a length, a payload and the CRC-32 of the payload.
+const (
+	snapshotMagic   = "CMIYCSNP"
+	snapshotVersion = 2
+
+	snapshotEntryRecord byte = 1
+	snapshotEndRecord   byte = 2
+
+	maxSnapshotRecordSize = 1 << 31
+)
+
+var ErrCorruptedSnapshot = errors.New("corrupted cache snapshot")
+
+type snapshotEntry struct {
+	key     Key
+	primary Key
+	entry   Entry
+	rank    float64 // position in the eviction order of its shard, 0 is evicted first
+}
+
+func shardEvictionOrder(s *shard) []Key {
+	if policy, ok := s.policy.(orderedPolicy); ok {
+		return policy.evictionOrder()
+	}
+
+	keys := make([]Key, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// SaveSnapshot writes the entries held in memory that are not expired to
+// path, through a temporary file renamed once complete.
+func (c *HttpCache) SaveSnapshot(path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	written, err := c.WriteSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return written, os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot restores the entries of a snapshot written by SaveSnapshot. The
+// snapshot is removed once loaded, a restart without a clean shutdown would
+// otherwise bring back the entries purged since.
+func (c *HttpCache) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	loaded, err := c.ReadSnapshot(bufio.NewReader(f))
+	_ = f.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return loaded, fmt.Errorf("removing loaded snapshot: %w", err)
+	}
+
+	return loaded, nil
+}
+
+// WriteSnapshot writes the entries from the first to the last the eviction
+// policies would evict, so that restoring a snapshot larger than the cache
+// keeps the most valuable entries.
+func (c *HttpCache) WriteSnapshot(w io.Writer) (int, error) {
+	var entries []snapshotEntry
+	for _, s := range c.shards {
+		s.Lock()
+		s.applyHits()
+		keys := shardEvictionOrder(s)
+		for i, key := range keys {
+			if entry, exists := s.entries[key]; exists && !entry.IsExpired() {
+				rank := float64(i) / float64(len(keys))
+				entries = append(entries, snapshotEntry{key: key, primary: s.primaryLocked(key), entry: entry, rank: rank})
+			}
+		}
+		s.Unlock()
+	}
+
+	// Shards are interleaved, restored entries are spread over shards anew.
+	slices.SortStableFunc(entries, func(a, b snapshotEntry) int {
+		return cmp.Compare(a.rank, b.rank)
+	})
+
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(snapshotVersion)); err != nil {
+		return 0, err
+	}
+
+	var payload bytes.Buffer
+	for _, e := range entries {
+		payload.Reset()
+		payload.WriteByte(snapshotEntryRecord)
+		writeString(&payload, e.key)
+		writeString(&payload, e.primary)
+		encodeEntry(&payload, e.entry)
+
+		if err := writeRecord(w, payload.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+
+	payload.Reset()
+	payload.WriteByte(snapshotEndRecord)
+	writeUint(&payload, uint64(len(entries)))
+
+	return len(entries), writeRecord(w, payload.Bytes())
+}
+
+// ReadSnapshot restores the entries of a snapshot that have not expired since
+// it was written. Nothing is restored from a snapshot failing its checks.
+func (c *HttpCache) ReadSnapshot(r io.Reader) (int, error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return 0, fmt.Errorf("%w: missing header", ErrCorruptedSnapshot)
+	}
+
+	var version uint16
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return 0, fmt.Errorf("%w: missing version", ErrCorruptedSnapshot)
+	}
+	if version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported cache snapshot version %d", version)
+	}
+
+	var entries []snapshotEntry
+	for {
+		payload, err := readRecord(r)
+		if err != nil {
+			return 0, err
+		}
+
+		d := &decoder{data: payload}
+		switch d.byte() {
+		case snapshotEntryRecord:
+			e := snapshotEntry{key: d.string(), primary: d.string()}
+			e.entry = decodeEntry(d)
+			if d.err != nil {
+				return 0, fmt.Errorf("%w: %v", ErrCorruptedSnapshot, d.err)
+			}
+			entries = append(entries, e)
+
+		case snapshotEndRecord:
+			if count := d.uint(); d.err != nil || count != uint64(len(entries)) {
+				return 0, fmt.Errorf("%w: expected %d entries, read %d", ErrCorruptedSnapshot, count, len(entries))
+			}
+			return c.restore(entries), nil
+
+		default:
+			return 0, fmt.Errorf("%w: unknown record", ErrCorruptedSnapshot)
+		}
+	}
+}
+
+func (c *HttpCache) restore(entries []snapshotEntry) int {
+	restored := 0
+	for _, e := range entries {
+		if e.entry.IsExpired() {
+			continue
+		}
+
+		varyHeaders := varyHeaderNames(e.entry.Vary)
+
+		if variantKey(e.primary, varyHeaders, e.entry.Vary) != e.key {
+			continue
+		}
+
+		if c.setVariant(c.Generation(), e.primary, varyHeaders, e.entry) {
+			restored++
+		}
+	}
+
+	return restored
+}
+
+func writeRecord(w io.Writer, payload []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(header[:], crc32.ChecksumIEEE(payload))
+	_, err := w.Write(header[:])
+
+	return err
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptedSnapshot)
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 || size > maxSnapshotRecordSize {
+		return nil, fmt.Errorf("%w: invalid record size %d", ErrCorruptedSnapshot, size)
+	}
+
+	// The size is not verified yet, the payload grows as it is read rather
+	// than being allocated upfront.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptedSnapshot)
+	}
+	payload := buf.Bytes()
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptedSnapshot)
+	}
+	if binary.BigEndian.Uint32(header[:]) != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptedSnapshot)
+	}
+
+	return payload, nil
+}
+
+// encodeEntry appends the binary representation of an entry to buf.
+func encodeEntry(buf *bytes.Buffer, entry Entry) {
+	writeString(buf, entry.Path)
+	writeUint(buf, uint64(entry.StatusCode))
+	writeHeader(buf, entry.Header)
+	writeTime(buf, entry.ExpiresAt)
+	writeTime(buf, entry.StoredAt)
+	writeUint(buf, uint64(entry.InitialAge))
+	writeHeader(buf, entry.Vary)
+	writeBytes(buf, entry.Body)
+	writeString(buf, string(entry.Encoding))
+}
+
+func decodeEntry(d *decoder) Entry {
+	return Entry{
+		Path:       d.string(),
+		StatusCode: int(d.uint()),
+		Header:     d.header(),
+		ExpiresAt:  d.time(),
+		StoredAt:   d.time(),
+		InitialAge: time.Duration(d.uint()),
+		Vary:       d.header(),
+		Body:       d.bytes(),
+		Encoding:   Encoding(d.string()),
+	}
+}
+
+func writeUint(buf *bytes.Buffer, v uint64) {
+	buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func writeTime(buf *bytes.Buffer, t time.Time) {
+	if t.IsZero() {
+		writeUint(buf, 0)
+		return
+	}
+
+	writeUint(buf, uint64(t.UnixNano()))
+}
+
+// writeHeader distinguishes a nil header from an empty one, the count is
+// shifted by one.
+func writeHeader(buf *bytes.Buffer, h http.Header) {
+	if h == nil {
+		writeUint(buf, 0)
+		return
+	}
+
+	writeUint(buf, uint64(len(h))+1)
+	for name, values := range h {
+		writeString(buf, name)
+		writeUint(buf, uint64(len(values)))
+		for _, value := range values {
+			writeString(buf, value)
+		}
+	}
+}
+
+// decoder reads the values written by encodeEntry, the first error is kept
+// and makes every following read return a zero value.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = io.ErrUnexpectedEOF
+	}
+	d.data = nil
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.data) == 0 {
+		d.fail()
+		return 0
+	}
+
+	b := d.data[0]
+	d.data = d.data[1:]
+
+	return b
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	size := d.uint()
+	if d.err != nil || size > uint64(len(d.data)) {
+		d.fail()
+		return nil
+	}
+
+	b := make([]byte, size)
+	copy(b, d.data)
+	d.data = d.data[size:]
+
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) time() time.Time {
+	nanos := d.uint()
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, int64(nanos))
+}
+
+func (d *decoder) header() http.Header {
+	count := d.uint()
+	if d.err != nil || count == 0 {
+		return nil
+	}
+	if count-1 > uint64(len(d.data)) {
+		d.fail()
+		return nil
+	}
+
+	h := make(http.Header, count-1)
+	for i := uint64(1); i < count && d.err == nil; i++ {
+		name := d.string()
+		n := d.uint()
+		if n > uint64(len(d.data)) {
+			d.fail()
+			return nil
+		}
+
+		values := make([]string, 0, n)
+		for j := uint64(0); j < n && d.err == nil; j++ {
+			values = append(values, d.string())
+		}
+		h[name] = values
+	}
+
+	return h
+}
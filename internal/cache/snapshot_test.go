@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	tagged := setTaggedEntry(c, "http://example.com/tagged", map[string]string{"Surrogate-Key": "tagged", "ETag": `"v1"`})
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"}), "Accept-Encoding", "gzipped")
+
+	expired := httptest.NewRequest("GET", "http://example.com/expired", nil)
+	resp := NewCachableResponse(httptest.NewRecorder())
+	resp.WriteHeader(http.StatusOK)
+	c.Set(expired, resp, time.Now().Add(-time.Minute))
+
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	written, err := c.SaveSnapshot(path)
+	if err != nil {
+		t.Fatalf("SaveSnapshot returned error: %v", err)
+	}
+	if written != 2 {
+		t.Errorf("expected expired entries to be left out, got %d written", written)
+	}
+
+	restored := newEmptyConfiguredCache(t)
+	loaded, err := restored.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot returned error: %v", err)
+	}
+	if loaded != 2 {
+		t.Errorf("expected 2 entries to be restored, got %d", loaded)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the loaded snapshot to be removed, got %v", err)
+	}
+
+	original, _ := c.Get(tagged)
+	entry, found := restored.Get(tagged)
+	if !found {
+		t.Fatal("expected entry to be restored")
+	}
+	if !reflect.DeepEqual(entry.Header, original.Header) || !bytes.Equal(entry.Body, original.Body) ||
+		!entry.ExpiresAt.Equal(original.ExpiresAt) || !entry.StoredAt.Equal(original.StoredAt) || entry.Path != original.Path {
+		t.Errorf("expected restored entry %+v to match %+v", entry, original)
+	}
+
+	if entry, found := restored.Get(requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"})); !found || string(entry.Body) != "gzipped" {
+		t.Error("expected variant to be restored")
+	}
+	if _, found := restored.Get(requestWithHeaders(nil)); found {
+		t.Error("expected the variant index to be restored")
+	}
+	if purged, _ := restored.Purge(PurgeSelector{Tags: []string{"tagged"}}); purged != 1 {
+		t.Errorf("expected tags to be restored, got %d purged", purged)
+	}
+}
+
+func TestSnapshotRejectsCorruption(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+	setTaggedEntry(c, "http://example.com/one", nil)
+	setTaggedEntry(c, "http://example.com/two", nil)
+
+	var snapshot bytes.Buffer
+	if _, err := c.WriteSnapshot(&snapshot); err != nil {
+		t.Fatalf("WriteSnapshot returned error: %v", err)
+	}
+	data := snapshot.Bytes()
+
+	flipped := bytes.Clone(data)
+	flipped[len(snapshotMagic)+2+8] ^= 0xff
+
+	// The first record claims 2 GiB, which must not be allocated upfront.
+	oversized := bytes.Clone(data)
+	copy(oversized[len(snapshotMagic)+2:], []byte{0x7f, 0xff, 0xff, 0xff})
+
+	newVersion := bytes.Clone(data)
+	newVersion[len(snapshotMagic)+1] = snapshotVersion + 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("NOTASNAP"), data[len(snapshotMagic):]...)},
+		{"unsupported version", newVersion},
+		{"flipped byte", flipped},
+		{"oversized record", oversized},
+		{"truncated", data[:len(data)-10]},
+		{"missing end record", data[:len(data)-9]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored := newEmptyConfiguredCache(t)
+			if _, err := restored.ReadSnapshot(bytes.NewReader(tt.data)); err == nil {
+				t.Fatal("expected snapshot to be rejected")
+			}
+			if entries := restored.Stats().Entries; entries != 0 {
+				t.Errorf("expected nothing to be restored, got %d entries", entries)
+			}
+		})
+	}
+
+	if _, err := newEmptyConfiguredCache(t).ReadSnapshot(bytes.NewReader(flipped)); !errors.Is(err, ErrCorruptedSnapshot) {
+		t.Errorf("expected ErrCorruptedSnapshot, got %v", err)
+	}
+}
+
+func TestSnapshotRestoresTheMostRecentlyUsedEntriesFirst(t *testing.T) {
+	c := newSingleShardCache(t)
+	var requests []*http.Request
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		requests = append(requests, setTaggedEntry(c, "http://example.com"+path, nil))
+	}
+	c.Get(requests[0])
+	applyHits(c)
+
+	var snapshot bytes.Buffer
+	if _, err := c.WriteSnapshot(&snapshot); err != nil {
+		t.Fatalf("WriteSnapshot returned error: %v", err)
+	}
+
+	entry, _ := c.Get(requests[0])
+	restored := newSingleShardCache(t)
+	restored.MaxSize = 2 * len(entry.Body)
+	if _, err := restored.ReadSnapshot(&snapshot); err != nil {
+		t.Fatalf("ReadSnapshot returned error: %v", err)
+	}
+
+	for i, expected := range []bool{true, false, false, true} {
+		if _, found := restored.Get(requests[i]); found != expected {
+			t.Errorf("%s: expected found to be %v", requests[i].URL.Path, expected)
+		}
+	}
+}
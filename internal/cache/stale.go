@@ -0,0 +1,23 @@
+package cache
+
+import "time"
+
+// StalePolicy tells for how long past its expiration an entry may still be
+// served, while it is refreshed in the background or when the backend fails.
+type StalePolicy struct {
+	WhileRevalidate time.Duration
+	IfError         time.Duration
+}
+
+func (p StalePolicy) window() time.Duration {
+	return max(p.WhileRevalidate, p.IfError)
+}
+
+func (entry *Entry) StalePolicy(defaults StalePolicy) StalePolicy {
+	return ParseCacheControl(entry.Header.Get("Cache-Control")).StalePolicy(defaults)
+}
+
+// IsStaleWithin tells if the entry expired less than window ago.
+func (entry *Entry) IsStaleWithin(window time.Duration) bool {
+	return entry.IsExpired() && time.Now().Before(entry.ExpiresAt.Add(window))
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Store is a cache of responses keyed by the requests they answer. HttpCache
+// holds entries in process, RedisStore in a server shared by several
+// instances.
+type Store interface {
+	// Get returns the fresh entry matching r.
+	Get(r *http.Request) (Entry, bool)
+	// Set stores the response to r until expiresAt.
+	Set(r *http.Request, resp *CachableResponse, expiresAt time.Time)
+	// Delete removes every variant stored for the resource targeted by r.
+	Delete(r *http.Request)
+	// Purge removes the entries matched by the selector.
+	Purge(selector PurgeSelector) (int, error)
+	Stats() Stats
+}
+
+// entryStore is a Store taking the entries HttpCache already built, rather
+// than building them again from the response.
+type entryStore interface {
+	storeEntry(r *http.Request, varyHeaders []string, entry Entry)
+}
+
+var (
+	_ Store      = (*HttpCache)(nil)
+	_ Store      = (*RedisStore)(nil)
+	_ entryStore = (*RedisStore)(nil)
+)
+
+func (c *HttpCache) Delete(r *http.Request) {
+	c.Invalidate(r)
+}
+
+// getShared returns the fresh entry the shared store holds for r, and keeps
+// a copy of it in this cache.
+func (c *HttpCache) getShared(r *http.Request) (Entry, bool) {
+	if c.shared == nil {
+		return Entry{}, false
+	}
+
+	entry, found := c.shared.Get(r)
+	if !found {
+		return Entry{}, false
+	}
+
+	c.setVariant(c.Generation(), c.KeyFor(r), varyHeaderNames(entry.Vary), entry)
+
+	return entry.Shared(), true
+}
+
+// setShared hands the entry stored for r to the shared store, responses are
+// only built into entries again for stores not taking them.
+func (c *HttpCache) setShared(r *http.Request, resp *CachableResponse, varyHeaders []string, entry Entry) {
+	switch shared := c.shared.(type) {
+	case nil:
+	case entryStore:
+		shared.storeEntry(r, varyHeaders, entry)
+	default:
+		if resp != nil {
+			shared.Set(r, resp, entry.ExpiresAt)
+		}
+	}
+}
+
+func (c *HttpCache) closeShared() {
+	if closer, ok := c.shared.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
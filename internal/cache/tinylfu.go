@@ -0,0 +1,182 @@
+package cache
+
+import "hash/maphash"
+
+const (
+	windowRatio    = 0.01
+	protectedRatio = 0.8
+	sketchDepth    = 4
+	sketchWidth    = 1 << 14
+	sketchMaxCount = 15
+)
+
+// wTinyLFUPolicy implements W-TinyLFU (Einziger, Friedman & Manes): new keys
+// enter a small LRU window, keys leaving the window only get into the main
+// segmented LRU if their estimated access frequency beats the main victim's,
+// which keeps one-hit wonders and scans from flushing the hot set.
+type wTinyLFUPolicy struct {
+	window    *lruList
+	probation *lruList
+	protected *lruList
+	sketch    *countMinSketch
+}
+
+func NewWTinyLFUPolicy() EvictionPolicy {
+	return &wTinyLFUPolicy{
+		window:    newLRUList(),
+		probation: newLRUList(),
+		protected: newLRUList(),
+		sketch:    newCountMinSketch(),
+	}
+}
+
+func (p *wTinyLFUPolicy) Add(key Key, size int) {
+	p.sketch.increment(key)
+
+	switch {
+	case p.window.contains(key):
+		p.window.add(key, size)
+	case p.probation.contains(key), p.protected.contains(key):
+		p.probation.remove(key)
+		p.protected.add(key, size)
+		p.rebalanceProtected()
+	default:
+		p.window.add(key, size)
+	}
+}
+
+func (p *wTinyLFUPolicy) Touch(key Key) {
+	p.sketch.increment(key)
+
+	if p.window.touch(key) || p.protected.touch(key) {
+		return
+	}
+
+	if size, found := p.probation.remove(key); found {
+		p.protected.add(key, size)
+		p.rebalanceProtected()
+	}
+}
+
+func (p *wTinyLFUPolicy) Remove(key Key) {
+	p.window.remove(key)
+	p.probation.remove(key)
+	p.protected.remove(key)
+}
+
+func (p *wTinyLFUPolicy) Evict(_ Key, incomingSize int) (Key, bool) {
+	// Evict is only called on a full cache, what is held is the capacity.
+	capacity := p.window.bytes + p.probation.bytes + p.protected.bytes
+	windowTarget := max(int(float64(capacity)*windowRatio), incomingSize)
+	mainTarget := capacity - windowTarget
+
+	for p.window.len() > 0 && p.window.bytes+incomingSize > windowTarget {
+		candidate, candidateSize, _ := p.window.popOldest()
+
+		if p.probation.bytes+p.protected.bytes+candidateSize <= mainTarget {
+			p.probation.add(candidate, candidateSize)
+			continue
+		}
+
+		victim, found := p.mainVictim()
+		if !found || p.sketch.estimate(candidate) <= p.sketch.estimate(victim) {
+			return candidate, true
+		}
+
+		p.removeFromMain(victim)
+		p.probation.add(candidate, candidateSize)
+
+		return victim, true
+	}
+
+	if victim, found := p.mainVictim(); found {
+		p.removeFromMain(victim)
+		return victim, true
+	}
+
+	key, _, found := p.window.popOldest()
+	return key, found
+}
+
+func (p *wTinyLFUPolicy) evictionOrder() []Key {
+	keys := append(p.window.keys(), p.probation.keys()...)
+	return append(keys, p.protected.keys()...)
+}
+
+func (p *wTinyLFUPolicy) mainVictim() (Key, bool) {
+	if key, _, found := p.probation.oldest(); found {
+		return key, true
+	}
+
+	key, _, found := p.protected.oldest()
+	return key, found
+}
+
+func (p *wTinyLFUPolicy) removeFromMain(key Key) {
+	p.probation.remove(key)
+	p.protected.remove(key)
+}
+
+func (p *wTinyLFUPolicy) rebalanceProtected() {
+	limit := int(float64(p.probation.bytes+p.protected.bytes) * protectedRatio)
+	for p.protected.bytes > limit && p.protected.len() > 1 {
+		key, size, _ := p.protected.popOldest()
+		p.probation.add(key, size)
+	}
+}
+
+// countMinSketch estimates key frequencies with saturating counters. All
+// counters are halved once enough increments happened so that the sketch
+// favours recent popularity.
+type countMinSketch struct {
+	counters  [sketchDepth][sketchWidth]uint8
+	seeds     [sketchDepth]maphash.Seed
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch() *countMinSketch {
+	s := &countMinSketch{resetAt: 10 * sketchWidth}
+	for i := range s.seeds {
+		s.seeds[i] = maphash.MakeSeed()
+	}
+
+	return s
+}
+
+func (s *countMinSketch) index(row int, key Key) uint64 {
+	return maphash.String(s.seeds[row], key) & (sketchWidth - 1)
+}
+
+func (s *countMinSketch) increment(key Key) {
+	for row := range s.counters {
+		idx := s.index(row, key)
+		if s.counters[row][idx] < sketchMaxCount {
+			s.counters[row][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(key Key) uint8 {
+	estimate := uint8(sketchMaxCount)
+	for row := range s.counters {
+		estimate = min(estimate, s.counters[row][s.index(row, key)])
+	}
+
+	return estimate
+}
+
+func (s *countMinSketch) reset() {
+	for row := range s.counters {
+		for i := range s.counters[row] {
+			s.counters[row][i] /= 2
+		}
+	}
+
+	s.additions /= 2
+}
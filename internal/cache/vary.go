@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type variantIndex struct {
+	headers []string
+	keys    map[Key]struct{}
+}
+
+// parseVary returns the sorted, canonical header names listed in the Vary
+// header, and whether the response varies on everything (Vary: *).
+func parseVary(h http.Header) ([]string, bool) {
+	var names []string
+
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, true
+			}
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names), false
+}
+
+// varyHeaderNames returns the sorted header names selecting a stored variant.
+func varyHeaderNames(selected http.Header) []string {
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func selectingHeaders(r *http.Request, names []string) http.Header {
+	if len(names) == 0 {
+		return nil
+	}
+
+	selected := make(http.Header, len(names))
+	for _, name := range names {
+		selected[name] = []string{normalizeHeaderValue(r.Header.Values(name))}
+	}
+
+	return selected
+}
+
+func normalizeHeaderValue(values []string) string {
+	var parts []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func variantKey(primary Key, names []string, selected http.Header) Key {
+	if len(names) == 0 {
+		return primary
+	}
+
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range names {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(selected.Get(name))
+	}
+
+	return b.String()
+}
+
+func sameHeaders(a, b []string) bool {
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func setVaryingEntry(c *HttpCache, r *http.Request, vary string, body string) {
+	rec := httptest.NewRecorder()
+	resp := NewCachableResponse(rec)
+	if vary != "" {
+		resp.Header().Set("Vary", vary)
+	}
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte(body))
+
+	c.Set(r, resp, time.Now().Add(ttl))
+}
+
+func requestWithHeaders(headers map[string]string) *http.Request {
+	r := httptest.NewRequest("GET", "http://example.com/resource", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	return r
+}
+
+func TestParseVary(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []string
+		expected []string
+		all      bool
+	}{
+		{"no vary", nil, nil, false},
+		{"single header", []string{"accept-encoding"}, []string{"Accept-Encoding"}, false},
+		{"sorted and deduplicated", []string{"Accept-Language, Accept-Encoding", "accept-language"}, []string{"Accept-Encoding", "Accept-Language"}, false},
+		{"wildcard", []string{"Accept-Encoding, *"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{"Vary": tt.values}
+			names, all := parseVary(h)
+			if all != tt.all {
+				t.Errorf("expected all=%v, got %v", tt.all, all)
+			}
+			if !sameHeaders(names, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestVaryStoresOneVariantPerSelectingHeaders(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	gzipReq := requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"})
+	identityReq := requestWithHeaders(map[string]string{"Accept-Encoding": "identity"})
+
+	setVaryingEntry(c, gzipReq, "Accept-Encoding", "gzipped")
+	setVaryingEntry(c, identityReq, "Accept-Encoding", "plain")
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		found    bool
+		expected string
+	}{
+		{"gzip variant", requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"}), true, "gzipped"},
+		{"identity variant", requestWithHeaders(map[string]string{"Accept-Encoding": "identity"}), true, "plain"},
+		{"whitespace is normalized", requestWithHeaders(map[string]string{"Accept-Encoding": " gzip "}), true, "gzipped"},
+		{"unknown variant", requestWithHeaders(map[string]string{"Accept-Encoding": "br"}), false, ""},
+		{"missing header is its own variant", requestWithHeaders(nil), false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, found := c.Get(tt.req)
+			if found != tt.found {
+				t.Fatalf("expected found=%v, got %v", tt.found, found)
+			}
+			if found && string(entry.Body) != tt.expected {
+				t.Errorf("expected body %q, got %q", tt.expected, entry.Body)
+			}
+		})
+	}
+}
+
+func TestVaryChangeDropsPreviousVariants(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"}), "Accept-Encoding", "gzipped")
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Language": "fr"}), "Accept-Language", "bonjour")
+
+	if _, found := c.Get(requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"})); found {
+		t.Error("expected variants selected by the previous Vary headers to be dropped")
+	}
+	if entry, found := c.Get(requestWithHeaders(map[string]string{"Accept-Language": "fr"})); !found || string(entry.Body) != "bonjour" {
+		t.Error("expected the new variant to be served")
+	}
+	if entries := c.Stats().Entries; entries != 1 {
+		t.Errorf("expected a single entry left, got %d", entries)
+	}
+}
+
+func TestVaryingResponseDropsTheEntryWithoutVary(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	setVaryingEntry(c, requestWithHeaders(nil), "", "plain")
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Language": "fr"}), "Accept-Language", "bonjour")
+
+	if entries := c.Stats().Entries; entries != 1 {
+		t.Errorf("expected the entry stored without Vary to be dropped, %d entries left", entries)
+	}
+	if size := c.CurrentSize(); size != len("bonjour") {
+		t.Errorf("expected only the new variant to count against the size, got %d", size)
+	}
+}
+
+func TestVaryWildcardIsNotCached(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	req := requestWithHeaders(nil)
+	setVaryingEntry(c, req, "*", "anything")
+
+	if _, found := c.Get(req); found {
+		t.Error("expected Vary: * responses to not be cached")
+	}
+
+	resp := NewCachableResponse(httptest.NewRecorder())
+	resp.Header().Set("Vary", "*")
+	if resp.IsCachable() {
+		t.Error("expected Vary: * responses to not be cachable")
+	}
+}
+
+func TestInvalidateDropsEveryVariant(t *testing.T) {
+	c := newEmptyConfiguredCache(t)
+
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Encoding": "gzip"}), "Accept-Encoding", "gzipped")
+	setVaryingEntry(c, requestWithHeaders(map[string]string{"Accept-Encoding": "identity"}), "Accept-Encoding", "plain")
+
+	c.Invalidate(requestWithHeaders(nil))
+
+	s := c.shardFor(c.KeyFor(requestWithHeaders(nil)))
+	if len(s.entries) != 0 || len(s.variants) != 0 || len(s.primaryOf) != 0 || c.CurrentSize() != 0 {
+		t.Errorf("expected every variant to be invalidated, got %d entries", len(s.entries))
+	}
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"sort"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -15,15 +17,125 @@ const (
 	LBStrategyRoundRobin LoadBalancerStrategy = "round_robin"
 )
 
+type ListenerProtocol string
+
+const (
+	ListenerProtocolHTTP  ListenerProtocol = "http"
+	ListenerProtocolHTTPS ListenerProtocol = "https"
+	ListenerProtocolH2C   ListenerProtocol = "h2c"
+)
+
+type ListenerTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type Listener struct {
+	Address  string            `yaml:"address"`
+	Protocol ListenerProtocol  `yaml:"protocol"`
+	TLS      ListenerTLSConfig `yaml:"tls"`
+	Routes   []string          `yaml:"routes"`
+
+	prioritizedRoutes []string
+}
+
+func (l *Listener) GetPrioritizedMatchingRoute(route string) (string, bool) {
+	return matchPrioritizedRoute(l.prioritizedRoutes, route)
+}
+
+type UpstreamTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (t UpstreamTLSConfig) IsZero() bool {
+	return t == UpstreamTLSConfig{}
+}
+
+type BackendProtocol string
+
+const (
+	BackendProtocolHTTP1 BackendProtocol = "http1"
+	BackendProtocolH2    BackendProtocol = "h2"
+	BackendProtocolH2C   BackendProtocol = "h2c"
+)
+
 type Backend struct {
-	URL string `yaml:"url"`
+	URL      string            `yaml:"url"`
+	TLS      UpstreamTLSConfig `yaml:"tls"`
+	Protocol BackendProtocol   `yaml:"protocol"`
+}
+
+type HealthCheckType string
+
+const (
+	HealthCheckHTTP HealthCheckType = "http"
+	HealthCheckGRPC HealthCheckType = "grpc"
+)
+
+type HealthCheckConfig struct {
+	Type     HealthCheckType `yaml:"type"`
+	Path     string          `yaml:"path"`
+	Service  string          `yaml:"service"`
+	Interval int             `yaml:"interval"`
+	Timeout  int             `yaml:"timeout"`
+}
+
+type QueryKeyConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+type CacheKeyConfig struct {
+	Template string         `yaml:"template"`
+	Query    QueryKeyConfig `yaml:"query"`
+	Headers  []string       `yaml:"headers"`
+	Cookies  []string       `yaml:"cookies"`
 }
 
 type CacheConfig struct {
-	Enabled      bool `yaml:"enabled"`
-	MaxSize      int  `yaml:"max_size"`
-	MaxEntrySize int  `yaml:"max_entry_size"`
-	TTL          int  `yaml:"ttl"`
+	Enabled              bool             `yaml:"enabled"`
+	MaxSize              int              `yaml:"max_size"`
+	MaxEntrySize         int              `yaml:"max_entry_size"`
+	TTL                  int              `yaml:"ttl"`
+	StaleWhileRevalidate int              `yaml:"stale_while_revalidate"` // in seconds, used when responses do not specify it
+	StaleIfError         int              `yaml:"stale_if_error"`         // in seconds, used when responses do not specify it
+	CoalesceTimeout      int              `yaml:"coalesce_timeout"`       // in seconds, -1 disables request coalescing
+	Eviction             string           `yaml:"eviction"`
+	Shards               int              `yaml:"shards"` // independently locked partitions of the cache, 16 by default
+	Key                  CacheKeyConfig   `yaml:"key"`
+	Disk                 DiskCacheConfig  `yaml:"disk"`
+	Snapshot             string           `yaml:"snapshot"`    // file saved on shutdown and loaded on startup, disabled when empty
+	Compression          string           `yaml:"compression"` // "gzip", "br" or "zstd", bodies are stored as is when empty
+	Redis                RedisCacheConfig `yaml:"redis"`
+}
+
+type RedisCacheConfig struct {
+	Address  string `yaml:"address"` // Redis compatible server shared by the instances, disabled when empty
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Prefix   string `yaml:"prefix"` // prepended to every key, "cmiyc:" by default
+}
+
+type DiskCacheConfig struct {
+	Path    string `yaml:"path"`     // directory of the disk tier, disabled when empty
+	MaxSize int    `yaml:"max_size"` // in MiB
+}
+
+type PurgeConfig struct {
+	Address string   `yaml:"address"` // admin listener serving the purge API, disabled when empty
+	Method  bool     `yaml:"method"`  // accept the PURGE method on the main listeners
+	Allow   []string `yaml:"allow"`   // CIDRs allowed to purge, loopback only when empty
+}
+
+type PeeringConfig struct {
+	Address string   `yaml:"address"` // listener serving cache fills to the peers, disabled when empty
+	Self    string   `yaml:"self"`    // URL of this instance's peering listener, as listed in peers
+	Peers   []string `yaml:"peers"`   // URLs of the peering listeners of every instance, this one included
+	Allow   []string `yaml:"allow"`   // CIDRs allowed to fetch from this instance, loopback only when empty
 }
 
 type LBConfig struct {
@@ -31,10 +143,53 @@ type LBConfig struct {
 }
 
 type Route struct {
-	LoadBalancerType LoadBalancerStrategy `yaml:"load_balancer_strategy"`
-	CacheConfig      CacheConfig          `yaml:"cache"`
-	LBConfig         LBConfig             `yaml:"lb"`
-	Backends         []Backend            `yaml:"backends"`
+	LoadBalancerType   LoadBalancerStrategy `yaml:"load_balancer_strategy"`
+	CacheConfig        CacheConfig          `yaml:"cache"`
+	LBConfig           LBConfig             `yaml:"lb"`
+	Backends           []Backend            `yaml:"backends"`
+	TLS                UpstreamTLSConfig    `yaml:"tls"`
+	UpgradeIdleTimeout int                  `yaml:"upgrade_idle_timeout"`
+	FlushIntervalMS    int                  `yaml:"flush_interval_ms"` // -1 flushes every write
+	Timeout            int                  `yaml:"timeout"`           // in seconds, bounds whole backend exchanges, -1 for streams
+	HealthCheck        HealthCheckConfig    `yaml:"health_check"`
+}
+
+func (r *Route) FlushIntervalDuration() time.Duration {
+	if r.FlushIntervalMS < 0 {
+		return -1
+	}
+
+	return time.Duration(r.FlushIntervalMS) * time.Millisecond
+}
+
+const DefaultRouteTimeout = 60 * time.Second
+
+// TimeoutDuration bounds a backend exchange, body included, 0 when the route
+// serves streams outliving any deadline (gRPC streams, Server-Sent Events).
+func (r *Route) TimeoutDuration() time.Duration {
+	switch {
+	case r.Timeout < 0:
+		return 0
+	case r.Timeout == 0:
+		return DefaultRouteTimeout
+	}
+
+	return time.Duration(r.Timeout) * time.Second
+}
+
+const DefaultCoalesceTimeout = 10 * time.Second
+
+// CoalesceTimeoutDuration is how long concurrent misses wait for the request
+// already fetching the same entry, 0 when coalescing is disabled.
+func (cc *CacheConfig) CoalesceTimeoutDuration() time.Duration {
+	switch {
+	case cc.CoalesceTimeout < 0:
+		return 0
+	case cc.CoalesceTimeout == 0:
+		return DefaultCoalesceTimeout
+	}
+
+	return time.Duration(cc.CoalesceTimeout) * time.Second
 }
 
 func (r *Route) ConfiguredURLs() []string {
@@ -45,9 +200,33 @@ func (r *Route) ConfiguredURLs() []string {
 	return urls
 }
 
+// Backend returns the backend of the route configured with url.
+func (r *Route) Backend(url string) (Backend, bool) {
+	for _, b := range r.Backends {
+		if b.URL == url {
+			return b, true
+		}
+	}
+
+	return Backend{}, false
+}
+
+// UpstreamTLS returns the TLS profile used to reach a backend, backend settings
+// taking precedence over the route ones.
+func (r *Route) UpstreamTLS(b Backend) UpstreamTLSConfig {
+	if !b.TLS.IsZero() {
+		return b.TLS
+	}
+
+	return r.TLS
+}
+
 type Config struct {
-	Routes map[string]Route `yaml:"routes"`
-	Listen string           `yaml:"listen"`
+	Routes    map[string]Route `yaml:"routes"`
+	Listen    string           `yaml:"listen"`
+	Listeners []Listener       `yaml:"listeners"`
+	Purge     PurgeConfig      `yaml:"purge"`
+	Peering   PeeringConfig    `yaml:"peering"`
 
 	prioritizedRoutes []string
 }
@@ -59,7 +238,11 @@ func (c *Config) GetConfigForRoute(route string) (*Route, bool) {
 }
 
 func (c *Config) GetPrioritizedMatchingRoute(route string) (string, bool) {
-	for _, r := range c.prioritizedRoutes {
+	return matchPrioritizedRoute(c.prioritizedRoutes, route)
+}
+
+func matchPrioritizedRoute(prioritizedRoutes []string, route string) (string, bool) {
+	for _, r := range prioritizedRoutes {
 		if len(route) >= len(r) && route[:len(r)] == r {
 			return r, true
 		}
@@ -80,17 +263,74 @@ func BuildConfigurationFromFile(path string) (*Config, error) {
 		return nil, err
 	}
 
-	config.prioritizedRoutes = sortRoutesByLength(config.Routes)
+	if err := config.prepareListeners(); err != nil {
+		return nil, err
+	}
 
 	return &config, err
 }
 
-func NewConfig(listen string, routes map[string]Route) Config {
-	return Config{
-		Listen:            listen,
-		Routes:            routes,
-		prioritizedRoutes: sortRoutesByLength(routes),
+func NewConfig(listen string, routes map[string]Route) (Config, error) {
+	config := Config{
+		Listen: listen,
+		Routes: routes,
+	}
+
+	err := config.prepareListeners()
+
+	return config, err
+}
+
+func NewConfigWithListeners(listeners []Listener, routes map[string]Route) (Config, error) {
+	config := Config{
+		Listeners: listeners,
+		Routes:    routes,
 	}
+
+	err := config.prepareListeners()
+
+	return config, err
+}
+
+func (c *Config) prepareListeners() error {
+	c.prioritizedRoutes = sortRoutesByLength(c.Routes)
+
+	if len(c.Listeners) == 0 {
+		c.Listeners = []Listener{{Address: c.Listen}}
+	}
+
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+
+		switch l.Protocol {
+		case "":
+			l.Protocol = ListenerProtocolHTTP
+		case ListenerProtocolHTTP, ListenerProtocolH2C:
+		case ListenerProtocolHTTPS:
+			if l.TLS.CertFile == "" || l.TLS.KeyFile == "" {
+				return fmt.Errorf("listener %s: https requires a certificate and a key", l.Address)
+			}
+		default:
+			return fmt.Errorf("listener %s: unknown protocol %s", l.Address, l.Protocol)
+		}
+
+		if len(l.Routes) == 0 {
+			l.prioritizedRoutes = c.prioritizedRoutes
+			continue
+		}
+
+		served := make(map[string]Route, len(l.Routes))
+		for _, r := range l.Routes {
+			route, exists := c.Routes[r]
+			if !exists {
+				return fmt.Errorf("listener %s: unknown route %s", l.Address, r)
+			}
+			served[r] = route
+		}
+		l.prioritizedRoutes = sortRoutesByLength(served)
+	}
+
+	return nil
 }
 
 func sortRoutesByLength(routes map[string]Route) []string {
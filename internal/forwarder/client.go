@@ -1,19 +1,91 @@
 package forwarder
 
 import (
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/papey/cmiyc/internal/config"
 )
 
 type Client struct {
-	client *http.Client
+	defaultUpstream *upstream
+	profiles        map[upstreamProfile]*upstream
+}
+
+// Upstream is a backend along with the TLS profile and protocol it is reached
+// with. The same URL may be reached differently by the routes listing it.
+type Upstream struct {
+	URL      string
+	TLS      config.UpstreamTLSConfig
+	Protocol config.BackendProtocol
+}
+
+type upstream struct {
+	client    *http.Client
+	tlsConfig *tls.Config
+}
+
+type upstreamProfile struct {
+	tls      config.UpstreamTLSConfig
+	protocol config.BackendProtocol
 }
 
 func NewClient() *Client {
+	return &Client{
+		defaultUpstream: newUpstream(nil, config.BackendProtocolHTTP1),
+		profiles:        make(map[upstreamProfile]*upstream),
+	}
+}
+
+func (u Upstream) profile() upstreamProfile {
+	protocol := u.Protocol
+	if protocol == "" {
+		protocol = config.BackendProtocolHTTP1
+	}
+
+	return upstreamProfile{tls: u.TLS, protocol: protocol}
+}
+
+func (c *Client) AddUpstream(dest Upstream) error {
+	profile := dest.profile()
+	if profile == (upstreamProfile{protocol: config.BackendProtocolHTTP1}) {
+		return nil
+	}
+	if _, exists := c.profiles[profile]; exists {
+		return nil
+	}
+
+	var tlsConfig *tls.Config
+	if !dest.TLS.IsZero() {
+		var err error
+		tlsConfig, err = NewTLSConfig(dest.TLS)
+		if err != nil {
+			return fmt.Errorf("upstream %s: %w", dest.URL, err)
+		}
+	}
+
+	c.profiles[profile] = newUpstream(tlsConfig, profile.protocol)
+
+	return nil
+}
+
+func (c *Client) HTTPClient(dest Upstream) *http.Client {
+	return c.upstreamFor(dest).client
+}
+
+func (c *Client) upstreamFor(dest Upstream) *upstream {
+	if u, exists := c.profiles[dest.profile()]; exists {
+		return u
+	}
+
+	return c.defaultUpstream
+}
+
+func newUpstream(tlsConfig *tls.Config, protocol config.BackendProtocol) *upstream {
 	transport := &http.Transport{
 		DialContext: (&net.Dialer{
 			Timeout:   10 * time.Second,
@@ -28,56 +100,126 @@ func NewClient() *Client {
 		ResponseHeaderTimeout: 20 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 
+		TLSClientConfig:   tlsConfig,
 		ForceAttemptHTTP2: true,
 	}
 
-	return &Client{
+	switch protocol {
+	case config.BackendProtocolH2:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+	case config.BackendProtocolH2C:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	// No client timeout: the deadline of each exchange is set per route on the
+	// request context, streaming routes disable it.
+	return &upstream{
 		client: &http.Client{
 			Transport: transport,
-			Timeout:   60 * time.Second,
 		},
+		tlsConfig: tlsConfig,
 	}
 }
 
-func (c *Client) ProxifyAndServe(w http.ResponseWriter, r *http.Request, dest string) error {
-	resp, err := c.proxify(r, dest)
+func (c *Client) ProxifyAndServe(w http.ResponseWriter, r *http.Request, dest Upstream, flushInterval time.Duration) error {
+	resp, err := c.Fetch(r, dest)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
-		_, _ = fmt.Fprintf(w, "Proxy error: %v", err)
-		return err
+		return WriteProxyError(w, r, err)
 	}
 	defer resp.Body.Close()
 
-	cleanHopByHopHeaders(resp.Header)
+	return ServeResponse(w, r, resp, flushInterval)
+}
 
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+// Fetch forwards r to dest and returns the backend response with its hop by
+// hop headers removed, the caller owns the response body.
+func (c *Client) Fetch(r *http.Request, dest Upstream) (*http.Response, error) {
+	return c.proxify(r, dest, nil)
+}
+
+// Revalidate forwards r to dest as a conditional request built from the
+// validators of a stored response, the client own validators are dropped.
+func (c *Client) Revalidate(r *http.Request, dest Upstream, etag string, lastModified string) (*http.Response, error) {
+	return c.proxify(r, dest, func(h http.Header) {
+		h.Del("If-None-Match")
+		h.Del("If-Modified-Since")
+
+		if etag != "" {
+			h.Set("If-None-Match", etag)
 		}
-	}
+		if lastModified != "" {
+			h.Set("If-Modified-Since", lastModified)
+		}
+	})
+}
+
+func ServeResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, flushInterval time.Duration) error {
+	copyHeaders(w.Header(), resp.Header)
+	announceTrailers(w.Header(), resp.Trailer)
 
 	addCacheHeaderOnCachableRequests(r.Method, w.Header())
 
 	w.WriteHeader(resp.StatusCode)
-	_, err = io.Copy(w, resp.Body)
-	return err
+	if err := copyBody(w, resp.Body, flushIntervalFor(resp, flushInterval)); err != nil {
+		return err
+	}
+
+	copyTrailers(w.Header(), resp.Trailer)
+
+	return nil
 }
 
-func (c *Client) proxify(r *http.Request, dest string) (*http.Response, error) {
-	backendURL, proxyURL, err := buildURLs(r, dest)
+func (c *Client) proxify(r *http.Request, dest Upstream, rewriteHeaders func(http.Header)) (*http.Response, error) {
+	backendURL, proxyURL, err := buildURLs(r, dest.URL)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(r.Method, proxyURL.String(), r.Body)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, proxyURL.String(), r.Body)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header = c.buildRequestHeaders(r)
+	if rewriteHeaders != nil {
+		rewriteHeaders(req.Header)
+	}
 	req.Host = backendURL.Host
+	req.ContentLength = r.ContentLength
+	if len(r.Trailer) > 0 {
+		req.Trailer = r.Trailer
+		req.ContentLength = -1
+	}
 
-	return c.client.Do(req)
+	resp, err := c.upstreamFor(dest).client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cleanHopByHopHeaders(resp.Header)
+
+	return resp, nil
+}
+
+func WriteProxyError(w http.ResponseWriter, r *http.Request, err error) error {
+	if IsGRPCRequest(r) {
+		writeGRPCError(w, err)
+		return err
+	}
+
+	w.WriteHeader(http.StatusBadGateway)
+	_, _ = fmt.Fprintf(w, "Proxy error: %v", err)
+	return err
+}
+
+func copyHeaders(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
 }
 
 func addCacheHeaderOnCachableRequests(method string, h http.Header) {
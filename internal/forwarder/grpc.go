@@ -0,0 +1,38 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	GRPCStatusDeadlineExceeded = 4
+	GRPCStatusUnavailable      = 14
+)
+
+func IsGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+func writeGRPCError(w http.ResponseWriter, err error) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(grpcStatusFromError(err)))
+	h.Set("Grpc-Message", url.PathEscape(err.Error()))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func grpcStatusFromError(err error) int {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return GRPCStatusDeadlineExceeded
+	}
+
+	return GRPCStatusUnavailable
+}
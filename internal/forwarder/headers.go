@@ -14,7 +14,6 @@ var HopByHopHeaderMap = map[string]struct{}{
 	"Proxy-Authenticate":  {},
 	"Proxy-Authorization": {},
 	"TE":                  {},
-	"Trailer":             {},
 	"Transfer-Encoding":   {},
 	"Upgrade":             {},
 }
@@ -23,6 +22,10 @@ func (c *Client) buildRequestHeaders(r *http.Request) http.Header {
 	h := c.forwardHeaders(r.Header)
 	c.addProxyHeaders(r, h)
 
+	if headerContainsToken(r.Header, "TE", "trailers") {
+		h.Set("TE", "trailers")
+	}
+
 	return h
 }
 
@@ -52,6 +55,21 @@ func (c *Client) addProxyHeaders(r *http.Request, h http.Header) {
 	}
 }
 
+func announceTrailers(h http.Header, trailers http.Header) {
+	h.Del("Trailer")
+	for key := range trailers {
+		h.Add("Trailer", key)
+	}
+}
+
+func copyTrailers(h http.Header, trailers http.Header) {
+	for key, values := range trailers {
+		for _, value := range values {
+			h.Add(http.TrailerPrefix+key, value)
+		}
+	}
+}
+
 func cleanHopByHopHeaders(h http.Header) {
 	for key := range HopByHopHeaderMap {
 		h.Del(key)
@@ -0,0 +1,96 @@
+package forwarder
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func flushIntervalFor(resp *http.Response, configured time.Duration) time.Duration {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return -1
+	}
+
+	if resp.ContentLength == -1 {
+		return -1
+	}
+
+	return configured
+}
+
+func copyBody(w http.ResponseWriter, body io.Reader, flushInterval time.Duration) error {
+	if flushInterval == 0 {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	fw := &flushingWriter{
+		w:          w,
+		controller: http.NewResponseController(w),
+		interval:   flushInterval,
+	}
+	defer fw.stop()
+
+	_, err := io.Copy(fw, body)
+	return err
+}
+
+type flushingWriter struct {
+	w          io.Writer
+	controller *http.ResponseController
+	interval   time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending bool
+}
+
+func (fw *flushingWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if fw.interval < 0 {
+		return n, fw.controller.Flush()
+	}
+
+	if !fw.pending {
+		fw.pending = true
+		if fw.timer == nil {
+			fw.timer = time.AfterFunc(fw.interval, fw.delayedFlush)
+		} else {
+			fw.timer.Reset(fw.interval)
+		}
+	}
+
+	return n, nil
+}
+
+func (fw *flushingWriter) delayedFlush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if !fw.pending {
+		return
+	}
+
+	_ = fw.controller.Flush()
+	fw.pending = false
+}
+
+func (fw *flushingWriter) stop() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.pending = false
+	if fw.timer != nil {
+		fw.timer.Stop()
+	}
+}
@@ -0,0 +1,46 @@
+package forwarder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/papey/cmiyc/internal/config"
+)
+
+func NewTLSConfig(profile config.UpstreamTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         profile.ServerName,
+		InsecureSkipVerify: profile.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if profile.CAFile != "" {
+		pem, err := os.ReadFile(profile.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", profile.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (profile.CertFile == "") != (profile.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be configured together")
+	}
+
+	if profile.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(profile.CertFile, profile.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,225 @@
+package forwarder
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultUpgradeIdleTimeout = 5 * time.Minute
+
+func IsUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && headerContainsToken(r.Header, "Connection", "upgrade")
+}
+
+func (c *Client) ProxyUpgrade(w http.ResponseWriter, r *http.Request, dest Upstream, idleTimeout time.Duration) error {
+	backendURL, proxyURL, err := buildURLs(r, dest.URL)
+	if err != nil {
+		return WriteProxyError(w, r, err)
+	}
+
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultUpgradeIdleTimeout
+	}
+
+	backendConn, err := c.dialUpstream(dest, backendURL)
+	if err != nil {
+		return WriteProxyError(w, r, err)
+	}
+	defer backendConn.Close()
+
+	req := buildUpgradeRequest(c, r, backendURL, proxyURL)
+	if err := req.Write(backendConn); err != nil {
+		return WriteProxyError(w, r, err)
+	}
+
+	backendReader := bufio.NewReader(backendConn)
+	resp, err := http.ReadResponse(backendReader, req)
+	if err != nil {
+		return WriteProxyError(w, r, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		cleanHopByHopHeaders(resp.Header)
+		copyHeaders(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fmt.Errorf("hijacking client connection: %w", err)
+	}
+	defer clientConn.Close()
+	_ = clientConn.SetDeadline(time.Time{})
+
+	upgradeHeader := resp.Header.Get("Upgrade")
+	cleanHopByHopHeaders(resp.Header)
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Upgrade", upgradeHeader)
+
+	if err := writeSwitchingProtocols(clientBuf.Writer, resp); err != nil {
+		return err
+	}
+
+	tracker := &idleTracker{timeout: idleTimeout}
+	tracker.touch()
+
+	return tracker.pipe(clientConn, clientBuf.Reader, backendConn, backendReader)
+}
+
+func (c *Client) dialUpstream(dest Upstream, backendURL *url.URL) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+
+	switch backendURL.Scheme {
+	case "https", "wss":
+		tlsConfig := c.upstreamFor(dest).tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = backendURL.Hostname()
+		}
+
+		return tls.DialWithDialer(dialer, "tcp", hostWithPort(backendURL, "443"), tlsConfig)
+	default:
+		return dialer.Dial("tcp", hostWithPort(backendURL, "80"))
+	}
+}
+
+func buildUpgradeRequest(c *Client, r *http.Request, backendURL, proxyURL *url.URL) *http.Request {
+	req := &http.Request{
+		Method:     r.Method,
+		URL:        proxyURL,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     c.buildRequestHeaders(r),
+		Host:       backendURL.Host,
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+
+	return req
+}
+
+func writeSwitchingProtocols(w *bufio.Writer, resp *http.Response) error {
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+type idleTracker struct {
+	timeout      time.Duration
+	lastActivity atomic.Int64
+}
+
+func (t *idleTracker) touch() {
+	t.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (t *idleTracker) isIdle() bool {
+	return time.Since(time.Unix(0, t.lastActivity.Load())) >= t.timeout
+}
+
+func (t *idleTracker) pipe(clientConn net.Conn, clientReader io.Reader, backendConn net.Conn, backendReader io.Reader) error {
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = t.copy(backendConn, clientConn, clientReader)
+		closeConns(clientConn, backendConn)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = t.copy(clientConn, backendConn, backendReader)
+		closeConns(clientConn, backendConn)
+	}()
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *idleTracker) copy(dst net.Conn, src net.Conn, srcReader io.Reader) error {
+	buf := make([]byte, 32*1024)
+
+	for {
+		_ = src.SetReadDeadline(time.Now().Add(t.timeout))
+		n, err := srcReader.Read(buf)
+		if n > 0 {
+			t.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if !t.isIdle() {
+					continue
+				}
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func closeConns(conns ...net.Conn) {
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+func hostWithPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
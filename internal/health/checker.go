@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/papey/cmiyc/internal/config"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+)
+
+type Probe interface {
+	Check(ctx context.Context, target string) error
+}
+
+type ClientFunc func(target string) *http.Client
+
+type Checker struct {
+	targets  []string
+	probe    Probe
+	interval time.Duration
+	timeout  time.Duration
+	healthy  map[string]*atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewChecker(targets []string, probe Probe, interval, timeout time.Duration) *Checker {
+	healthy := make(map[string]*atomic.Bool, len(targets))
+	for _, t := range targets {
+		healthy[t] = &atomic.Bool{}
+		healthy[t].Store(true)
+	}
+
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Checker{
+		targets:  targets,
+		probe:    probe,
+		interval: interval,
+		timeout:  timeout,
+		healthy:  healthy,
+		stop:     make(chan struct{}),
+	}
+}
+
+func NewCheckerFromConfig(targets []string, cfg config.HealthCheckConfig, clients ClientFunc) (*Checker, error) {
+	var probe Probe
+
+	switch cfg.Type {
+	case config.HealthCheckHTTP:
+		probe = &HTTPProbe{Clients: clients, Path: cfg.Path}
+	case config.HealthCheckGRPC:
+		probe = &GRPCProbe{Clients: clients, Service: cfg.Service}
+	default:
+		return nil, fmt.Errorf("unknown health check type %s", cfg.Type)
+	}
+
+	return NewChecker(targets, probe, time.Duration(cfg.Interval)*time.Second, time.Duration(cfg.Timeout)*time.Second), nil
+}
+
+func (c *Checker) Start() {
+	c.checkAll()
+
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.checkAll()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Checker) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+func (c *Checker) Healthy(target string) bool {
+	status, exists := c.healthy[target]
+	return !exists || status.Load()
+}
+
+func (c *Checker) checkAll() {
+	var wg sync.WaitGroup
+
+	for _, target := range c.targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			c.check(target)
+		}(target)
+	}
+
+	wg.Wait()
+}
+
+func (c *Checker) check(target string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	err := c.probe.Check(ctx, target)
+	healthy := err == nil
+
+	if previous := c.healthy[target].Swap(healthy); previous != healthy {
+		if healthy {
+			log.Printf("Backend %s is healthy again", target)
+		} else {
+			log.Printf("Backend %s is unhealthy: %v", target, err)
+		}
+	}
+}
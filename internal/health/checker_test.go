@@ -0,0 +1,45 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckerTracksBackendHealth(t *testing.T) {
+	var failing atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	probe := &HTTPProbe{Clients: func(string) *http.Client { return http.DefaultClient }, Path: "/healthz"}
+	checker := NewChecker([]string{backend.URL, "http://127.0.0.1:1"}, probe, 10*time.Millisecond, time.Second)
+	checker.Start()
+	defer checker.Stop()
+
+	if !checker.Healthy(backend.URL) {
+		t.Error("expected responding backend to be healthy")
+	}
+	if checker.Healthy("http://127.0.0.1:1") {
+		t.Error("expected unreachable backend to be unhealthy")
+	}
+	if !checker.Healthy("http://not-checked") {
+		t.Error("expected unchecked backends to be considered healthy")
+	}
+
+	failing.Store(true)
+	deadline := time.Now().Add(time.Second)
+	for checker.Healthy(backend.URL) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if checker.Healthy(backend.URL) {
+		t.Error("expected failing backend to become unhealthy")
+	}
+}
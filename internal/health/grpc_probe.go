@@ -0,0 +1,162 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+// grpc.health.v1.HealthCheckResponse.ServingStatus
+const grpcServingStatusServing = 1
+
+// GRPCProbe implements the gRPC health checking protocol by hand, messages are
+// tiny enough to not require a protobuf dependency.
+type GRPCProbe struct {
+	Clients ClientFunc
+	Service string
+}
+
+func (p *GRPCProbe) Check(ctx context.Context, target string) error {
+	body := encodeGRPCFrame(encodeHealthCheckRequest(p.Service))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(target, "/")+grpcHealthCheckPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := p.Clients(target).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if status := grpcHeader(resp, "Grpc-Status"); status != "0" {
+		message, _ := url.PathUnescape(grpcHeader(resp, "Grpc-Message"))
+		return fmt.Errorf("grpc-status %s: %s", status, message)
+	}
+
+	message, err := decodeGRPCFrame(payload)
+	if err != nil {
+		return err
+	}
+
+	status, err := decodeHealthCheckResponse(message)
+	if err != nil {
+		return err
+	}
+
+	if status != grpcServingStatusServing {
+		return fmt.Errorf("service not serving (status %d)", status)
+	}
+
+	return nil
+}
+
+func grpcHeader(resp *http.Response, key string) string {
+	if value := resp.Trailer.Get(key); value != "" {
+		return value
+	}
+
+	return resp.Header.Get(key)
+}
+
+func encodeGRPCFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+
+	return append(frame, message...)
+}
+
+func decodeGRPCFrame(payload []byte) ([]byte, error) {
+	if len(payload) < 5 {
+		return nil, errors.New("truncated grpc frame")
+	}
+
+	if payload[0] != 0 {
+		return nil, errors.New("compressed grpc frames are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(payload[1:5])
+	if uint32(len(payload)-5) < size {
+		return nil, errors.New("truncated grpc message")
+	}
+
+	return payload[5 : 5+size], nil
+}
+
+// HealthCheckRequest { string service = 1; }
+func encodeHealthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+
+	message := []byte{0x0a}
+	message = binary.AppendUvarint(message, uint64(len(service)))
+
+	return append(message, service...)
+}
+
+// HealthCheckResponse { ServingStatus status = 1; }
+func decodeHealthCheckResponse(message []byte) (uint64, error) {
+	var status uint64
+
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errors.New("malformed health check response")
+		}
+		message = message[n:]
+
+		field, wireType := tag>>3, tag&0x7
+		switch wireType {
+		case 0:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[n:]
+			if field == 1 {
+				status = value
+			}
+		case 1:
+			if len(message) < 8 {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[8:]
+		case 2:
+			size, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < size {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[n+int(size):]
+		case 5:
+			if len(message) < 4 {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[4:]
+		default:
+			return 0, fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newH2CServer(t *testing.T, handler http.Handler) *httptest.Server {
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func h2cClient(string) *http.Client {
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Client{Transport: transport}
+}
+
+func healthServer(t *testing.T, statuses map[string]uint64) *httptest.Server {
+	return newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcHealthCheckPath || r.ProtoMajor != 2 {
+			http.NotFound(w, r)
+			return
+		}
+
+		payload, _ := io.ReadAll(r.Body)
+		message, err := decodeGRPCFrame(payload)
+		if err != nil {
+			t.Errorf("invalid request frame: %v", err)
+			return
+		}
+
+		service := ""
+		if len(message) > 2 {
+			service = string(message[2:])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		status, known := statuses[service]
+		if !known {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown%20service")
+			return
+		}
+
+		_, _ = w.Write(encodeGRPCFrame([]byte{0x08, byte(status)}))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+}
+
+func TestGRPCProbe(t *testing.T) {
+	ts := healthServer(t, map[string]uint64{
+		"":            grpcServingStatusServing,
+		"api.Users":   grpcServingStatusServing,
+		"api.Billing": 2, // NOT_SERVING
+	})
+
+	tests := []struct {
+		name    string
+		service string
+		healthy bool
+	}{
+		{"overall server", "", true},
+		{"serving service", "api.Users", true},
+		{"not serving service", "api.Billing", false},
+		{"unknown service", "api.Unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := &GRPCProbe{Clients: h2cClient, Service: tt.service}
+			err := probe.Check(context.Background(), ts.URL)
+			if (err == nil) != tt.healthy {
+				t.Errorf("expected healthy=%v, got error %v", tt.healthy, err)
+			}
+		})
+	}
+}
+
+func TestDecodeHealthCheckResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  []byte
+		expected uint64
+		wantErr  bool
+	}{
+		{"empty message defaults to unknown", nil, 0, false},
+		{"serving", []byte{0x08, 0x01}, 1, false},
+		{"unknown fields are skipped", []byte{0x12, 0x02, 'o', 'k', 0x08, 0x02}, 2, false},
+		{"truncated varint", []byte{0x08}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeHealthCheckResponse(tt.message)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
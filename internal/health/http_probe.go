@@ -0,0 +1,39 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type HTTPProbe struct {
+	Clients ClientFunc
+	Path    string
+}
+
+func (p *HTTPProbe) Check(ctx context.Context, target string) error {
+	path := p.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(target, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.Clients(target).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
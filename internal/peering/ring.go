@@ -0,0 +1,73 @@
+package peering
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// pointsPerPeer is the number of positions each peer takes on the ring, more
+// points spread keys more evenly.
+const pointsPerPeer = 128
+
+// Ring assigns keys to peers by consistent hashing: every instance built from
+// the same peers agrees on the owners, and removing a peer only moves the
+// keys it owned.
+type Ring struct {
+	points []point
+}
+
+type point struct {
+	hash uint64
+	peer string
+}
+
+func NewRing(peers []string) *Ring {
+	ring := &Ring{points: make([]point, 0, len(peers)*pointsPerPeer)}
+
+	for _, peer := range peers {
+		for i := 0; i < pointsPerPeer; i++ {
+			ring.points = append(ring.points, point{hash: hash(peer + "#" + strconv.Itoa(i)), peer: peer})
+		}
+	}
+
+	slices.SortFunc(ring.points, func(a, b point) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.peer, b.peer))
+	})
+
+	return ring
+}
+
+// Owner returns the peer owning key, the first one found clockwise from the
+// position of key.
+func (r *Ring) Owner(key string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+
+	i, _ := slices.BinarySearchFunc(r.points, hash(key), func(p point, target uint64) int {
+		return cmp.Compare(p.hash, target)
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.points[i].peer, true
+}
+
+// hash is FNV-1a followed by the splitmix64 finalizer, FNV alone clusters the
+// hashes of strings sharing a long prefix.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
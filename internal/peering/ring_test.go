@@ -0,0 +1,66 @@
+package peering
+
+import (
+	"fmt"
+	"testing"
+)
+
+var peers = []string{"http://10.0.0.1:9000", "http://10.0.0.2:9000", "http://10.0.0.3:9000"}
+
+func keys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("http://example.com/item/%d", i)
+	}
+
+	return keys
+}
+
+func TestEmptyRingHasNoOwner(t *testing.T) {
+	if _, found := NewRing(nil).Owner("key"); found {
+		t.Error("expected an empty ring to have no owner")
+	}
+}
+
+func TestRingOwnersDoNotDependOnPeersOrder(t *testing.T) {
+	ring := NewRing(peers)
+	reversed := NewRing([]string{peers[2], peers[1], peers[0]})
+
+	for _, key := range keys(1000) {
+		owner, _ := ring.Owner(key)
+		if other, _ := reversed.Owner(key); owner != other {
+			t.Fatalf("expected %s to have the same owner, got %s and %s", key, owner, other)
+		}
+	}
+}
+
+func TestRingSpreadsKeys(t *testing.T) {
+	ring := NewRing(peers)
+
+	owned := make(map[string]int)
+	for _, key := range keys(30000) {
+		owner, _ := ring.Owner(key)
+		owned[owner]++
+	}
+
+	for _, peer := range peers {
+		if owned[peer] < 7000 || owned[peer] > 13000 {
+			t.Errorf("expected keys to be spread evenly, %s owns %d of 30000", peer, owned[peer])
+		}
+	}
+}
+
+func TestRingOnlyMovesKeysOfRemovedPeers(t *testing.T) {
+	ring := NewRing(peers)
+	shrunk := NewRing(peers[:2])
+
+	for _, key := range keys(1000) {
+		owner, _ := ring.Owner(key)
+		if owner == peers[2] {
+			continue
+		}
+		if other, _ := shrunk.Owner(key); other != owner {
+			t.Errorf("expected %s to stay on %s, moved to %s", key, owner, other)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package reverser
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/papey/cmiyc/internal/cache"
+)
+
+type cacheKey struct {
+	cache *cache.HttpCache
+	key   cache.Key
+}
+
+// flightGroup collapses concurrent cache misses on the same key into a single
+// upstream fetch, followers are then served from what the leader stored.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[cacheKey]chan struct{}
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[cacheKey]chan struct{})}
+}
+
+// join returns the channel closed when the flight for key lands, and whether
+// the caller leads it and must call land once done.
+func (g *flightGroup) join(key cacheKey) (chan struct{}, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if done, exists := g.flights[key]; exists {
+		return done, false
+	}
+
+	done := make(chan struct{})
+	g.flights[key] = done
+
+	return done, true
+}
+
+func (g *flightGroup) land(key cacheKey, done chan struct{}) {
+	g.mu.Lock()
+	if g.flights[key] == done {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+
+	close(done)
+}
+
+// wait blocks until the flight lands, it gives up when the timeout expires or
+// the client goes away.
+func (g *flightGroup) wait(r *http.Request, done chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
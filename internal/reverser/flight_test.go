@@ -0,0 +1,53 @@
+package reverser
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupElectsOneLeader(t *testing.T) {
+	g := newFlightGroup()
+	key := cacheKey{key: "GET /api"}
+
+	done, leader := g.join(key)
+	if !leader {
+		t.Fatal("expected first caller to lead")
+	}
+
+	followerDone, leader := g.join(key)
+	if leader || followerDone != done {
+		t.Fatal("expected second caller to follow the first flight")
+	}
+
+	if _, leader := g.join(cacheKey{key: "GET /other"}); !leader {
+		t.Error("expected other keys to get their own flight")
+	}
+
+	g.land(key, done)
+
+	if !g.wait(httptest.NewRequest("GET", "/api", nil), followerDone, time.Second) {
+		t.Error("expected followers to be released when the flight lands")
+	}
+
+	if _, leader := g.join(key); !leader {
+		t.Error("expected a new flight once the previous one landed")
+	}
+}
+
+func TestFlightGroupWaitTimeout(t *testing.T) {
+	g := newFlightGroup()
+	key := cacheKey{key: "GET /api"}
+
+	done, _ := g.join(key)
+	defer g.land(key, done)
+
+	start := time.Now()
+	if g.wait(httptest.NewRequest("GET", "/api", nil), done, 20*time.Millisecond) {
+		t.Fatal("expected wait to time out")
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("wait took too long: %v", time.Since(start))
+	}
+}
@@ -0,0 +1,305 @@
+package reverser
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/papey/cmiyc/internal/cache"
+	"github.com/papey/cmiyc/internal/config"
+	"github.com/papey/cmiyc/internal/forwarder"
+	"github.com/papey/cmiyc/internal/peering"
+)
+
+// PeerHeader carries the URL of the instance forwarding a cache miss to the
+// owner of its key. Requests received from a peer are never forwarded again.
+const PeerHeader = "X-Cmiyc-Peer"
+
+// peerPurgeTimeout bounds each purge sent to a peer.
+const peerPurgeTimeout = 5 * time.Second
+
+// maxQueuedPeerPurges bounds the purges waiting to be sent to the peers, later
+// ones are dropped.
+const maxQueuedPeerPurges = 1024
+
+// peerRetryDelay is how long the keys of an unreachable peer are fetched from
+// the backends instead.
+const peerRetryDelay = 10 * time.Second
+
+type peerSet struct {
+	self  string
+	peers []string
+	ring  *peering.Ring
+	acl   acl
+
+	mu          sync.Mutex
+	down        map[string]time.Time // unreachable peers, until they can be retried
+	settleUntil time.Time            // end of the spread of the last purge received from a peer
+
+	purges peerPurges
+}
+
+// peerPurges holds the purge queries waiting to be sent to the peers.
+type peerPurges struct {
+	mu      sync.Mutex
+	queue   []string
+	queued  map[string]struct{}
+	sending bool
+}
+
+type fromPeerKey struct{}
+
+func newPeerSet(cfg config.PeeringConfig) (*peerSet, error) {
+	if len(cfg.Peers) == 0 {
+		if cfg.Address != "" {
+			return nil, fmt.Errorf("peering listener %s has no peers", cfg.Address)
+		}
+		return nil, nil
+	}
+
+	peers := make([]string, 0, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		u, err := url.Parse(peer)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid peer URL %s", peer)
+		}
+		peers = append(peers, strings.TrimSuffix(peer, "/"))
+	}
+
+	self := strings.TrimSuffix(cfg.Self, "/")
+	if !slices.Contains(peers, self) {
+		return nil, fmt.Errorf("self %q is not one of the peers", cfg.Self)
+	}
+
+	allowed, err := newACL(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	return &peerSet{
+		self:  self,
+		peers: peers,
+		ring:  peering.NewRing(peers),
+		acl:   allowed,
+		down:  make(map[string]time.Time),
+	}, nil
+}
+
+// ownerOf returns the peer owning key when it is another, reachable instance
+// and r did not come from a peer.
+func (p *peerSet) ownerOf(r *http.Request, key cache.Key) (string, bool) {
+	if p == nil || r.Context().Value(fromPeerKey{}) != nil {
+		return "", false
+	}
+
+	owner, found := p.ring.Owner(key)
+	if !found || owner == p.self || p.settling() {
+		return "", false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until, down := p.down[owner]; down {
+		if time.Now().Before(until) {
+			return "", false
+		}
+		delete(p.down, owner)
+	}
+
+	return owner, true
+}
+
+// settling tells if purges are still spreading to the peers. Misses then go to
+// the backends, an owner not purged yet would hand back the purged entries.
+func (p *peerSet) settling() bool {
+	p.purges.mu.Lock()
+	sending := p.purges.sending
+	p.purges.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return sending || time.Now().Before(p.settleUntil)
+}
+
+// settle is called on the purges received from a peer, which sends them to
+// the other peers within peerPurgeTimeout.
+func (p *peerSet) settle() {
+	p.mu.Lock()
+	p.settleUntil = time.Now().Add(peerPurgeTimeout)
+	p.mu.Unlock()
+}
+
+func (p *peerSet) markDown(peer string) {
+	p.mu.Lock()
+	p.down[peer] = time.Now().Add(peerRetryDelay)
+	p.mu.Unlock()
+}
+
+// peerHandler serves the routes to the other instances, which forward their
+// misses on the keys this instance owns, and applies the purges they
+// broadcast.
+func (rev *Reverser) peerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rev.peers.acl.allows(r) || r.Header.Get(PeerHeader) == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if r.Method == MethodPurge {
+			rev.peers.settle()
+			rev.handlePurgeQuery(w, r, false)
+			return
+		}
+
+		route, found := rev.config.GetPrioritizedMatchingRoute(r.URL.Path)
+		if !found {
+			http.Error(w, "Route not found", http.StatusNotFound)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), fromPeerKey{}, true))
+		r.Header.Del(PeerHeader)
+
+		// The request is keyed as it was by the instance the client reached.
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			r.Host = host
+		}
+		if r.Header.Get("X-Forwarded-Proto") == "https" {
+			r.TLS = &tls.ConnectionState{}
+		}
+
+		rev.serveRoute(w, r, route, rev.config.GetPrioritizedMatchingRoute)
+	})
+}
+
+// fill fetches a missed response from the owner of its cache key when it is a
+// peer, and from the backend when this instance owns it or the owner cannot be
+// reached.
+func (rev *Reverser) fill(resp *cache.CachableResponse, r *http.Request, rc *config.Route, baseURL string, routeCache *cache.HttpCache) error {
+	if cache.IsRequestCachable(r.Method) {
+		if owner, remote := rev.peers.ownerOf(r, routeCache.KeyFor(r)); remote {
+			req := r.Clone(r.Context())
+			req.Header.Set(PeerHeader, rev.peers.self)
+
+			upstream, err := rev.client.Fetch(req, forwarder.Upstream{URL: owner})
+			if err == nil {
+				defer upstream.Body.Close()
+				return forwarder.ServeResponse(resp, r, upstream, rc.FlushIntervalDuration())
+			}
+
+			log.Printf("Peer %s unreachable, fetching %s from the backend: %v", owner, r.URL.Path, err)
+			rev.peers.markDown(owner)
+		}
+	}
+
+	return rev.client.ProxifyAndServe(resp, r, upstreamOf(rc, baseURL), rc.FlushIntervalDuration())
+}
+
+// broadcastPurge queues a purge of the caches of the route for every other
+// peer, which may have stored copies of the purged entries or own their keys.
+// Purges are sent in the background, identical queued ones only once.
+func (rev *Reverser) broadcastPurge(route string, selector cache.PurgeSelector) {
+	if rev.peers == nil {
+		return
+	}
+
+	q := &rev.peers.purges
+	query := purgeQuery(route, selector).Encode()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, queued := q.queued[query]; queued {
+		return
+	}
+	if len(q.queue) >= maxQueuedPeerPurges {
+		log.Printf("Dropping purge %s, %d purges already wait for the peers", query, len(q.queue))
+		return
+	}
+
+	if q.queued == nil {
+		q.queued = make(map[string]struct{})
+	}
+	q.queue = append(q.queue, query)
+	q.queued[query] = struct{}{}
+
+	if !q.sending {
+		q.sending = true
+		go rev.sendQueuedPurges()
+	}
+}
+
+// sendQueuedPurges sends the queued purges to the peers until none is left.
+func (rev *Reverser) sendQueuedPurges() {
+	q := &rev.peers.purges
+
+	for {
+		q.mu.Lock()
+		batch := q.queue
+		q.queue, q.queued = nil, nil
+		if len(batch) == 0 {
+			q.sending = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, peer := range rev.peers.peers {
+			if peer == rev.peers.self {
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rev.sendPurges(peer, batch)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// sendPurges sends a batch of purges to a peer, the rest of the batch is given
+// up after a failure so that an unreachable peer holds the queue back once.
+func (rev *Reverser) sendPurges(peer string, queries []string) {
+	for i, query := range queries {
+		ctx, cancel := context.WithTimeout(context.Background(), peerPurgeTimeout)
+		err := rev.sendPurge(ctx, peer, "/?"+query)
+		cancel()
+
+		if err != nil {
+			log.Printf("Purges on peer %s failed, %d not sent: %v", peer, len(queries)-i, err)
+			return
+		}
+	}
+}
+
+func (rev *Reverser) sendPurge(ctx context.Context, peer, target string) error {
+	req, err := http.NewRequestWithContext(ctx, MethodPurge, peer+target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(PeerHeader, rev.peers.self)
+
+	resp, err := rev.client.HTTPClient(forwarder.Upstream{URL: peer}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer answered %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,313 @@
+package reverser
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/papey/cmiyc/internal/config"
+)
+
+// startPeers runs count reversers sharing backendURL, each serving its peering
+// endpoint on a loopback listener.
+func startPeers(t *testing.T, count int, backendURL string) []*Reverser {
+	return startConfiguredPeers(t, count, backendURL, nil)
+}
+
+// startConfiguredPeers is startPeers with configure called on the
+// configuration of each instance.
+func startConfiguredPeers(t *testing.T, count int, backendURL string, configure func(i int, cfg *config.Config)) []*Reverser {
+	servers := make([]*httptest.Server, count)
+	peers := make([]string, count)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		peers[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+
+	instances := make([]*Reverser, count)
+	for i, server := range servers {
+		cfg := makeCachedConfig(backendURL)
+		cfg.Peering = config.PeeringConfig{Self: peers[i], Peers: peers}
+		if configure != nil {
+			configure(i, &cfg)
+		}
+
+		instances[i] = NewReverser(cfg)
+		server.Config.Handler = instances[i].peerHandler()
+		server.Start()
+		t.Cleanup(server.Close)
+	}
+
+	return instances
+}
+
+func TestPeersFetchFromTheOwnerOfEachKey(t *testing.T) {
+	var mu sync.Mutex
+	fetched := make(map[string]int)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[r.URL.Path]++
+		mu.Unlock()
+
+		_, _ = io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	instances := startPeers(t, 3, backend.URL)
+
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("/api/item/%d", i)
+		for _, rev := range instances {
+			w := httptest.NewRecorder()
+			rev.handleRequest(w, httptest.NewRequest("GET", path, nil))
+
+			if w.Code != http.StatusOK || w.Body.String() != "content of "+path {
+				t.Fatalf("unexpected response for %s: %d %q", path, w.Code, w.Body.String())
+			}
+		}
+	}
+
+	for path, count := range fetched {
+		if count != 1 {
+			t.Errorf("expected %s to be fetched from the backend once, got %d", path, count)
+		}
+	}
+
+}
+
+func TestPeersFallBackToTheBackendWhenTheOwnerIsDown(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "from backend")
+	}))
+	defer backend.Close()
+
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	self := "http://127.0.0.1:1"
+	cfg := makeCachedConfig(backend.URL)
+	cfg.Peering = config.PeeringConfig{Self: self, Peers: []string{self, down.URL}}
+	rev := NewReverser(cfg)
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", fmt.Sprintf("/api/item/%d", i), nil))
+
+		if w.Code != http.StatusOK || w.Body.String() != "from backend" {
+			t.Fatalf("expected the backend to answer, got %d %q", w.Code, w.Body.String())
+		}
+	}
+}
+
+// versionedBackend answers with the current version of the resources, bumped
+// by each POST.
+func versionedBackend(t *testing.T) *httptest.Server {
+	var version atomic.Int32
+	version.Store(1)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			version.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "v%d of %s", version.Load(), r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+
+	return backend
+}
+
+// waitForPeerPurges waits until rev sent every purge it queued for its peers.
+func waitForPeerPurges(t *testing.T, rev *Reverser) {
+	t.Helper()
+
+	q := &rev.peers.purges
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		q.mu.Lock()
+		sending := q.sending
+		q.mu.Unlock()
+
+		if !sending {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("purges were not sent to the peers in time")
+		}
+	}
+}
+
+func getFromAll(t *testing.T, instances []*Reverser, paths []string, expected string) {
+	t.Helper()
+
+	for _, path := range paths {
+		for i, rev := range instances {
+			w := httptest.NewRecorder()
+			rev.handleRequest(w, httptest.NewRequest("GET", path, nil))
+
+			if body := w.Body.String(); body != fmt.Sprintf(expected, path) {
+				t.Errorf("instance %d: unexpected body for %s: %q", i, path, body)
+			}
+		}
+	}
+}
+
+func TestPeersApplyPurgesEverywhere(t *testing.T) {
+	backend := versionedBackend(t)
+	instances := startPeers(t, 3, backend.URL)
+
+	paths := make([]string, 10)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/api/item/%d", i)
+	}
+	getFromAll(t, instances, paths, "v1 of %s")
+
+	// Bump the version behind the back of the caches.
+	if _, err := http.Post(backend.URL+"/api/item", "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	instances[0].handleAdminPurge(w, httptest.NewRequest("POST", "/purge?prefix=/api/item", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("purge failed: %d %s", w.Code, w.Body.String())
+	}
+
+	// The purge is applied to the instance receiving it before it answers.
+	getFromAll(t, instances[:1], paths[:1], "v2 of %s")
+
+	waitForPeerPurges(t, instances[0])
+	getFromAll(t, instances, paths, "v2 of %s")
+}
+
+func TestPeersApplyInvalidationsEverywhere(t *testing.T) {
+	backend := versionedBackend(t)
+	instances := startPeers(t, 3, backend.URL)
+
+	paths := make([]string, 10)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/api/item/%d", i)
+	}
+	getFromAll(t, instances, paths, "v1 of %s")
+
+	for i, path := range paths {
+		w := httptest.NewRecorder()
+		instances[0].handleRequest(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("unexpected response to POST %s: %d", path, w.Code)
+		}
+
+		waitForPeerPurges(t, instances[0])
+		getFromAll(t, instances, []string{path}, fmt.Sprintf("v%d of %%s", i+2))
+	}
+}
+
+func TestUnreachablePeersDoNotDelayInvalidations(t *testing.T) {
+	backend := versionedBackend(t)
+
+	stalled := make(chan struct{})
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer peer.Close()
+	defer close(stalled)
+
+	self := "http://127.0.0.1:1"
+	cfg := makeCachedConfig(backend.URL)
+	cfg.Peering = config.PeeringConfig{Self: self, Peers: []string{self, peer.URL}}
+	rev := NewReverser(cfg)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	rev.handleRequest(w, httptest.NewRequest("POST", "/api/item", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > peerPurgeTimeout/2 {
+		t.Errorf("expected the response not to wait for the peers, took %v", elapsed)
+	}
+}
+
+func TestPeersLeaveTheSharedStoreToTheReceivingInstance(t *testing.T) {
+	backend := versionedBackend(t)
+
+	// Only the peers use this store, the instance receiving the purge has
+	// none.
+	store, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := store.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			_ = conn.Close()
+		}
+	}()
+
+	instances := startConfiguredPeers(t, 3, backend.URL, func(i int, cfg *config.Config) {
+		if i > 0 {
+			route := cfg.Routes["/api"]
+			route.CacheConfig.Redis.Address = store.Addr().String()
+			cfg.Routes["/api"] = route
+		}
+	})
+
+	w := httptest.NewRecorder()
+	instances[0].handleAdminPurge(w, httptest.NewRequest("POST", "/purge?prefix=/api/item", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("purge failed: %d %s", w.Code, w.Body.String())
+	}
+	waitForPeerPurges(t, instances[0])
+
+	if n := connections.Load(); n != 0 {
+		t.Errorf("expected the peers to leave the shared store alone, %d connections", n)
+	}
+}
+
+func TestPeerEndpointRequiresThePeerHeader(t *testing.T) {
+	rev := NewReverser(makeCachedConfig("http://localhost"))
+	rev.peers = &peerSet{acl: acl{}}
+
+	w := httptest.NewRecorder()
+	rev.peerHandler().ServeHTTP(w, httptest.NewRequest("GET", "/api/item", nil))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected requests without %s to be rejected, got %d", PeerHeader, w.Code)
+	}
+}
+
+func TestNewPeerSetValidatesTheConfiguration(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.PeeringConfig
+	}{
+		{"listener without peers", config.PeeringConfig{Address: ":9000"}},
+		{"self not listed", config.PeeringConfig{Self: "http://a:9000", Peers: []string{"http://b:9000"}}},
+		{"invalid peer", config.PeeringConfig{Self: "http://a:9000", Peers: []string{"http://a:9000", "b:9000"}}},
+		{"invalid ACL", config.PeeringConfig{Self: "http://a:9000", Peers: []string{"http://a:9000"}, Allow: []string{"nope"}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := newPeerSet(tt.cfg); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	if peers, err := newPeerSet(config.PeeringConfig{}); peers != nil || err != nil {
+		t.Errorf("expected peering to be disabled, got %v, %v", peers, err)
+	}
+}
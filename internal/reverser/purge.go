@@ -0,0 +1,188 @@
+package reverser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/papey/cmiyc/internal/cache"
+)
+
+const MethodPurge = "PURGE"
+
+type acl struct {
+	nets []*net.IPNet
+}
+
+func newACL(cidrs []string) (acl, error) {
+	if len(cidrs) == 0 {
+		cidrs = []string{"127.0.0.0/8", "::1/128"}
+	}
+
+	var a acl
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return acl{}, fmt.Errorf("invalid ACL entry %s: %w", cidr, err)
+		}
+		a.nets = append(a.nets, ipNet)
+	}
+
+	return a, nil
+}
+
+func (a acl) allows(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range a.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminHandler serves the purge API: POST /purge with key, prefix, glob or
+// tag (repeatable) query parameters, optionally restricted to a route.
+func (rev *Reverser) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /purge", rev.handleAdminPurge)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rev.purgeACL.allows(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (rev *Reverser) handleAdminPurge(w http.ResponseWriter, r *http.Request) {
+	rev.handlePurgeQuery(w, r, true)
+}
+
+// handlePurgeQuery purges the selector given in the query of r, from the
+// caches of every route or of the route query parameter.
+func (rev *Reverser) handlePurgeQuery(w http.ResponseWriter, r *http.Request, broadcast bool) {
+	query := r.URL.Query()
+	selector := cache.PurgeSelector{
+		Key:    query.Get("key"),
+		Prefix: query.Get("prefix"),
+		Glob:   query.Get("glob"),
+		Tags:   query["tag"],
+	}
+
+	caches := rev.caches
+	if route := query.Get("route"); route != "" {
+		routeCache, exists := rev.getCacheForRoute(route)
+		if !exists {
+			http.Error(w, "Route cache not found", http.StatusNotFound)
+			return
+		}
+		caches = map[string]*cache.HttpCache{route: routeCache}
+	}
+
+	rev.purge(w, caches, selector, broadcast)
+}
+
+func purgeQuery(route string, selector cache.PurgeSelector) url.Values {
+	query := url.Values{"route": {route}}
+	for name, value := range map[string]string{"key": selector.Key, "prefix": selector.Prefix, "glob": selector.Glob} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	for _, tag := range selector.Tags {
+		query.Add("tag", tag)
+	}
+
+	return query
+}
+
+// servePurgeMethod handles PURGE requests on the main listeners, the request
+// URL is purged, or the tags given in its Surrogate-Key or Cache-Tag headers.
+func (rev *Reverser) servePurgeMethod(w http.ResponseWriter, r *http.Request, route string) {
+	if !rev.purgeACL.allows(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	routeCache, exists := rev.getCacheForRoute(route)
+	if !exists {
+		http.Error(w, "Route cache not found", http.StatusNotFound)
+		return
+	}
+
+	selector := cache.PurgeSelector{Key: routeCache.KeyFor(r)}
+	if tags := cache.Tags(r.Header); len(tags) > 0 {
+		selector = cache.PurgeSelector{Tags: tags}
+	}
+
+	rev.purge(w, map[string]*cache.HttpCache{route: routeCache}, selector, true)
+}
+
+// purge answers with the number of entries purged from the caches. When
+// broadcast is set the purge was received by this instance, which applies it
+// to the shared store and queues it for the peers. Peers only purge their own
+// caches.
+//
+// Invalid selectors are refused before any cache is touched. A shared store
+// failing does not stop the purge of the other routes, the answer is then a
+// 502, or a 503 when the store had no connection left, with the entries purged
+// nonetheless.
+func (rev *Reverser) purge(w http.ResponseWriter, caches map[string]*cache.HttpCache, selector cache.PurgeSelector, broadcast bool) {
+	if err := selector.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	purged := 0
+	var errs []error
+	for route, routeCache := range caches {
+		purge := routeCache.PurgeLocal
+		if broadcast {
+			purge = routeCache.Purge
+		}
+
+		n, err := purge(selector)
+		purged += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", route, err))
+		}
+
+		if broadcast {
+			rev.broadcastPurge(route, selector)
+		}
+	}
+
+	result := struct {
+		Purged int    `json:"purged"`
+		Error  string `json:"error,omitempty"`
+	}{Purged: purged}
+
+	status := http.StatusOK
+	if err := errors.Join(errs...); err != nil {
+		result.Error = err.Error()
+		status = http.StatusBadGateway
+		if errors.Is(err, cache.ErrRedisBusy) {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(result)
+}
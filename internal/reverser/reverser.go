@@ -2,32 +2,56 @@ package reverser
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/papey/cmiyc/internal/balancer"
 	"github.com/papey/cmiyc/internal/cache"
 	"github.com/papey/cmiyc/internal/config"
 	"github.com/papey/cmiyc/internal/forwarder"
+	"github.com/papey/cmiyc/internal/health"
 )
 
 type Reverser struct {
-	config config.Config
-	client *forwarder.Client
-	server *http.Server
-	caches map[string]*cache.HttpCache
-	lbs    map[string]balancer.Balancer
+	config   config.Config
+	client   *forwarder.Client
+	servers  []*http.Server
+	caches   map[string]*cache.HttpCache
+	lbs      map[string]balancer.Balancer
+	checkers []*health.Checker
+
+	purgeACL   acl
+	peers      *peerSet // nil when peering is disabled
+	flights    *flightGroup
+	refreshing sync.Map // cacheKey of in flight background refreshes
 }
 
 func NewReverser(cfg config.Config) *Reverser {
 	caches := make(map[string]*cache.HttpCache)
 	lbs := make(map[string]balancer.Balancer)
+	client := forwarder.NewClient()
+	var checkers []*health.Checker
 
 	for k, c := range cfg.Routes {
+		for _, b := range c.Backends {
+			if err := client.AddUpstream(upstreamOf(&c, b.URL)); err != nil {
+				log.Fatalf("Invalid upstream configuration for route %s: %v", k, err)
+			}
+		}
+
 		if c.CacheConfig.Enabled {
-			caches[k] = cache.NewEmptyCache(c.CacheConfig.MaxSize, c.CacheConfig.MaxEntrySize)
+			opts, err := cacheOptions(c.CacheConfig)
+			if err != nil {
+				log.Fatalf("Invalid cache configuration for route %s: %v", k, err)
+			}
+
+			caches[k] = cache.NewEmptyCacheWithOptions(c.CacheConfig.MaxSize, c.CacheConfig.MaxEntrySize, opts)
+			loadSnapshot(k, caches[k], c.CacheConfig.Snapshot)
 		}
 
 		switch c.LBConfig.Type {
@@ -42,22 +66,101 @@ func NewReverser(cfg config.Config) *Reverser {
 			lbs[k] = balancer.NewSingleLB(c.ConfiguredURLs())
 		}
 
+		if c.HealthCheck.Type != "" {
+			checker, err := health.NewCheckerFromConfig(c.ConfiguredURLs(), c.HealthCheck, func(target string) *http.Client {
+				return client.HTTPClient(upstreamOf(&c, target))
+			})
+			if err != nil {
+				log.Fatalf("Invalid health check configuration for route %s: %v", k, err)
+			}
+
+			checker.Start()
+			checkers = append(checkers, checker)
+			lbs[k] = balancer.NewHealthAwareLB(lbs[k], c.ConfiguredURLs(), checker.Healthy)
+		}
 	}
 
 	if len(lbs) != len(cfg.Routes) {
 		log.Fatalf("Some routes do not have a load balancer configured")
 	}
 
+	purgeACL, err := newACL(cfg.Purge.Allow)
+	if err != nil {
+		log.Fatalf("Invalid purge configuration: %v", err)
+	}
+
+	peers, err := newPeerSet(cfg.Peering)
+	if err != nil {
+		log.Fatalf("Invalid peering configuration: %v", err)
+	}
+
 	r := &Reverser{
-		config: cfg,
-		client: forwarder.NewClient(),
-		caches: caches,
-		lbs:    lbs,
+		config:   cfg,
+		client:   client,
+		caches:   caches,
+		lbs:      lbs,
+		checkers: checkers,
+		purgeACL: purgeACL,
+		peers:    peers,
+		flights:  newFlightGroup(),
 	}
 
 	return r
 }
 
+func cacheOptions(cc config.CacheConfig) (cache.Options, error) {
+	policy, err := cache.NewEvictionPolicyFactory(cache.EvictionPolicyType(cc.Eviction))
+	if err != nil {
+		return cache.Options{}, err
+	}
+
+	keyBuilder, err := cache.NewKeyBuilder(cache.KeyConfig{
+		Template:     cc.Key.Template,
+		IncludeQuery: cc.Key.Query.Include,
+		ExcludeQuery: cc.Key.Query.Exclude,
+		Headers:      cc.Key.Headers,
+		Cookies:      cc.Key.Cookies,
+	})
+	if err != nil {
+		return cache.Options{}, err
+	}
+
+	stale := cache.StalePolicy{
+		WhileRevalidate: time.Duration(cc.StaleWhileRevalidate) * time.Second,
+		IfError:         time.Duration(cc.StaleIfError) * time.Second,
+	}
+
+	compression, err := cache.ParseEncoding(cc.Compression)
+	if err != nil {
+		return cache.Options{}, err
+	}
+
+	var disk *cache.DiskStore
+	if cc.Disk.Path != "" {
+		if disk, err = cache.NewDiskStore(cc.Disk.Path, cc.Disk.MaxSize); err != nil {
+			return cache.Options{}, err
+		}
+	}
+
+	var shared cache.Store
+	if cc.Redis.Address != "" {
+		shared = cache.NewRedisStore(cache.RedisOptions{
+			Address:     cc.Redis.Address,
+			Password:    cc.Redis.Password,
+			DB:          cc.Redis.DB,
+			Prefix:      cc.Redis.Prefix,
+			Key:         keyBuilder,
+			Stale:       stale,
+			Compression: compression,
+			OnError: func(err error) {
+				log.Printf("Shared cache %s: %v", cc.Redis.Address, err)
+			},
+		})
+	}
+
+	return cache.Options{Eviction: policy, Shards: cc.Shards, Key: keyBuilder, Stale: stale, Disk: disk, Compression: compression, Shared: shared}, nil
+}
+
 func (rev *Reverser) handleRequest(w http.ResponseWriter, r *http.Request) {
 	matchingRoute, found := rev.config.GetPrioritizedMatchingRoute(r.URL.Path)
 	if !found {
@@ -65,6 +168,26 @@ func (rev *Reverser) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rev.serveRoute(w, r, matchingRoute, rev.config.GetPrioritizedMatchingRoute)
+}
+
+func (rev *Reverser) listenerHandler(l config.Listener) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matchingRoute, found := l.GetPrioritizedMatchingRoute(r.URL.Path)
+		if !found {
+			http.Error(w, "Route not found", http.StatusNotFound)
+			return
+		}
+
+		rev.serveRoute(w, r, matchingRoute, l.GetPrioritizedMatchingRoute)
+	})
+}
+
+// routeMatcher resolves a path to the route serving it, in the route table of
+// the listener a request was received on.
+type routeMatcher func(path string) (string, bool)
+
+func (rev *Reverser) serveRoute(w http.ResponseWriter, r *http.Request, matchingRoute string, match routeMatcher) {
 	c, ok := rev.config.GetConfigForRoute(matchingRoute)
 	if !ok {
 		http.Error(w, "Route configuration not found", http.StatusInternalServerError)
@@ -77,10 +200,31 @@ func (rev *Reverser) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == MethodPurge && rev.config.Purge.Method {
+		rev.servePurgeMethod(w, r, matchingRoute)
+		return
+	}
+
+	if forwarder.IsUpgradeRequest(r) {
+		err := rev.proxyUpgrade(w, r, c, lb)
+		if err != nil {
+			log.Println(err)
+		}
+
+		return
+	}
+
+	if timeout := c.TimeoutDuration(); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
 	resp := cache.NewCachableResponse(w)
+	defer rev.invalidateAfterUnsafe(r, resp, match)
 
 	if !c.CacheConfig.Enabled {
-		err := rev.proxyDirect(resp, r, lb.Pick())
+		err := rev.proxyDirect(resp, r, c, lb.Pick())
 		if err != nil {
 			log.Println(err)
 			return
@@ -91,7 +235,7 @@ func (rev *Reverser) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	routeCache, exists := rev.getCacheForRoute(matchingRoute)
 	if !exists {
-		err := rev.proxyDirect(resp, r, lb.Pick())
+		err := rev.proxyDirect(resp, r, c, lb.Pick())
 		if err != nil {
 			log.Println(err)
 			return
@@ -106,41 +250,270 @@ func (rev *Reverser) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (rev *Reverser) proxyDirect(resp *cache.CachableResponse, r *http.Request, baseURL string) error {
-	if err := rev.client.ProxifyAndServe(resp, r, baseURL); err != nil {
+// invalidateAfterUnsafe drops the cached entries a successful unsafe request
+// made outdated, in the cache of the route each of them belongs to on the
+// listener the request was received on and on every peer.
+func (rev *Reverser) invalidateAfterUnsafe(r *http.Request, resp *cache.CachableResponse, match routeMatcher) {
+	if len(rev.caches) == 0 {
+		return
+	}
+
+	for _, target := range cache.InvalidationTargets(r, resp.StatusCode, resp.Header()) {
+		route, found := match(target.URL.Path)
+		if !found {
+			continue
+		}
+
+		if routeCache, exists := rev.getCacheForRoute(route); exists {
+			routeCache.Invalidate(target)
+			rev.broadcastPurge(route, cache.PurgeSelector{Key: routeCache.KeyFor(target)})
+		}
+	}
+}
+
+func (rev *Reverser) proxyDirect(resp *cache.CachableResponse, r *http.Request, rc *config.Route, baseURL string) error {
+	resp.StopCapture()
+
+	if err := rev.client.ProxifyAndServe(resp, r, upstreamOf(rc, baseURL), rc.FlushIntervalDuration()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+func (rev *Reverser) proxyUpgrade(w http.ResponseWriter, r *http.Request, rc *config.Route, lb balancer.Balancer) error {
+	// Upgraded connections last, they are spread by count rather than by
+	// request.
+	dest := lb.PickLeastConnected()
+
+	lb.Acquire(dest)
+	defer lb.Release(dest)
+
+	return rev.client.ProxyUpgrade(w, r, upstreamOf(rc, dest), time.Duration(rc.UpgradeIdleTimeout)*time.Second)
+}
+
 func (rev *Reverser) proxyCache(resp *cache.CachableResponse, r *http.Request, rc *config.Route, baseURL string, routeCache *cache.HttpCache) error {
+	generation := routeCache.Generation()
 	isRequestCachable := cache.IsRequestCachable(r.Method)
 	if isRequestCachable {
-		served, err := routeCache.ServeIfPresent(resp.ResponseWriter, r)
-		if served {
+		reqCC := cache.ParseRequestCacheControl(r)
+
+		entry, found := routeCache.Lookup(r)
+		if found && entry.Satisfies(reqCC) {
+			if entry.IsExpired() {
+				return serveStale(resp.ResponseWriter, r, entry)
+			}
+
+			return entry.WriteResponse(resp.ResponseWriter, r)
+		}
+
+		if reqCC.OnlyIfCached {
+			http.Error(resp.ResponseWriter, "Not in cache", http.StatusGatewayTimeout)
 			return nil
 		}
 
-		if err != nil {
-			log.Println(err)
+		if found && !reqCC.NoCache && entry.IsStaleWithin(routeCache.StalePolicy(entry).WhileRevalidate) {
+			rev.refreshInBackground(r, rc, baseURL, routeCache, entry)
+			return serveStale(resp.ResponseWriter, r, entry)
+		}
+
+		if timeout := rc.CacheConfig.CoalesceTimeoutDuration(); timeout > 0 {
+			flight := cacheKey{cache: routeCache, key: r.Method + " " + routeCache.KeyFor(r)}
+			done, leader := rev.flights.join(flight)
+			if leader {
+				defer rev.flights.land(flight, done)
+			} else if rev.flights.wait(r, done, timeout) {
+				// Uncachable or differently varying responses are not in the
+				// cache, followers then fetch on their own.
+				if fresh, stored := routeCache.Get(r); stored && fresh.Satisfies(reqCC) {
+					return fresh.WriteResponse(resp.ResponseWriter, r)
+				}
+			}
+		}
+
+		if found {
+			return rev.revalidate(resp, r, rc, baseURL, routeCache, entry)
 		}
 	}
 
-	err := rev.client.ProxifyAndServe(resp, r, baseURL)
+	if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+		return rev.fillRange(resp, r, rc, baseURL, routeCache, generation)
+	}
+
+	resp.MaxBodySize = routeCache.CaptureLimit()
+	if !isRequestCachable {
+		resp.StopCapture()
+	}
+
+	err := rev.fill(resp, r, rc, baseURL, routeCache)
+	if err != nil {
+		return err
+	}
+
+	rev.storeResponse(generation, resp, r, rc, routeCache)
+
+	return nil
+}
+
+// errRangeFillUncachable aborts the download of a whole resource missed by a
+// Range request once it is known not to be stored.
+var errRangeFillUncachable = errors.New("response to the range fill is not cachable")
+
+// fillRange fetches the whole resource missed by a Range request, without its
+// Range and If-Range headers, so that it gets stored and the ranges are sliced
+// from it. Responses that cannot be stored are dropped as soon as this is
+// known, and the Range request is then forwarded as is.
+func (rev *Reverser) fillRange(resp *cache.CachableResponse, r *http.Request, rc *config.Route, baseURL string, routeCache *cache.HttpCache, generation uint64) error {
+	full := r.Clone(r.Context())
+	full.Header.Del("Range")
+	full.Header.Del("If-Range")
+
+	capture := &rangeFillResponse{discardResponse: discardResponse{header: make(http.Header)}}
+	capture.resp = cache.NewCachableResponse(capture)
+	capture.resp.MaxBodySize = routeCache.CaptureLimit()
+
+	err := rev.fill(capture.resp, full, rc, baseURL, routeCache)
+	if err == nil && rev.storeResponse(generation, capture.resp, full, rc, routeCache) {
+		if entry, found := routeCache.Get(r); found {
+			return entry.WriteResponse(resp.ResponseWriter, r)
+		}
+	}
+	if err != nil && !errors.Is(err, errRangeFillUncachable) {
+		log.Printf("Fetching %s for a range request failed: %v", r.URL.Path, err)
+	}
+
+	resp.StopCapture()
+
+	return rev.fill(resp, r, rc, baseURL, routeCache)
+}
+
+// rangeFillResponse discards the whole response fetched by fillRange, and
+// fails writes once the response is known not to be stored.
+type rangeFillResponse struct {
+	discardResponse
+	resp *cache.CachableResponse
+}
+
+func (f *rangeFillResponse) WriteHeader(int) {
+	if !f.resp.IsCachable() {
+		f.resp.StopCapture()
+	}
+}
+
+func (f *rangeFillResponse) Write(data []byte) (int, error) {
+	if !f.resp.IsCaptured() {
+		return 0, errRangeFillUncachable
+	}
+
+	return len(data), nil
+}
+
+func (rev *Reverser) revalidate(resp *cache.CachableResponse, r *http.Request, rc *config.Route, baseURL string, routeCache *cache.HttpCache, stale cache.Entry) error {
+	generation := routeCache.Generation()
+	canServeStale := stale.IsStaleWithin(routeCache.StalePolicy(stale).IfError)
+
+	upstream, err := rev.client.Revalidate(r, upstreamOf(rc, baseURL), stale.Header.Get("ETag"), stale.Header.Get("Last-Modified"))
 	if err != nil {
+		if canServeStale {
+			log.Printf("Serving stale response for %s: %v", r.URL.Path, err)
+			return serveStale(resp.ResponseWriter, r, stale)
+		}
+
+		return forwarder.WriteProxyError(resp, r, err)
+	}
+	defer upstream.Body.Close()
+
+	if upstream.StatusCode >= http.StatusInternalServerError && canServeStale {
+		log.Printf("Serving stale response for %s: backend answered %d", r.URL.Path, upstream.StatusCode)
+		return serveStale(resp.ResponseWriter, r, stale)
+	}
+
+	if upstream.StatusCode == http.StatusNotModified {
+		entry := stale.Revalidated(upstream.Header)
+		entry.ExpiresAt = time.Now().Add(cacheDurationWithFallback(entry.Header, time.Duration(rc.CacheConfig.TTL)*time.Second))
+		routeCache.Refresh(r, entry)
+
+		return entry.WriteResponse(resp.ResponseWriter, r)
+	}
+
+	resp.MaxBodySize = routeCache.CaptureLimit()
+	if r.Method != http.MethodGet {
+		resp.StopCapture()
+	}
+
+	if err := forwarder.ServeResponse(resp, r, upstream, rc.FlushIntervalDuration()); err != nil {
 		return err
 	}
 
-	contextAllowsCaching := isRequestCachable && ((withoutAuthorizationHeader(r) && resp.IsCachable()) || resp.IsCachableConsideringAuth())
-	if contextAllowsCaching {
-		cacheDuration := cacheDurationWithFallback(resp, time.Duration(rc.CacheConfig.TTL)*time.Second)
-		routeCache.Set(r, resp, time.Now().Add(cacheDuration))
+	if !rev.storeResponse(generation, resp, r, rc, routeCache) {
+		routeCache.Invalidate(r)
 	}
 
 	return nil
 }
 
+const backgroundRefreshTimeout = 30 * time.Second
+
+// refreshInBackground revalidates a stale entry served under
+// stale-while-revalidate, at most one refresh per key is in flight.
+func (rev *Reverser) refreshInBackground(r *http.Request, rc *config.Route, baseURL string, routeCache *cache.HttpCache, stale cache.Entry) {
+	key := cacheKey{cache: routeCache, key: routeCache.KeyFor(r)}
+	if _, inFlight := rev.refreshing.LoadOrStore(key, struct{}{}); inFlight {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundRefreshTimeout)
+	req := r.Clone(ctx)
+	req.Body = http.NoBody
+	req.ContentLength = 0
+
+	go func() {
+		defer rev.refreshing.Delete(key)
+		defer cancel()
+
+		resp := cache.NewCachableResponse(&discardResponse{header: make(http.Header)})
+		if err := rev.revalidate(resp, req, rc, baseURL, routeCache, stale); err != nil {
+			log.Printf("Background refresh of %s failed: %v", req.URL.Path, err)
+		}
+	}()
+}
+
+func serveStale(w http.ResponseWriter, r *http.Request, entry cache.Entry) error {
+	entry.Header = entry.Header.Clone()
+	entry.Header.Set("X-Cache", "STALE")
+
+	return entry.WriteResponse(w, r)
+}
+
+// discardResponse is the response writer of background refreshes, only the
+// cache is interested in what the backend answers.
+type discardResponse struct {
+	header http.Header
+}
+
+func (d *discardResponse) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponse) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (d *discardResponse) WriteHeader(int) {}
+
+// storeResponse caches the response unless the request context forbids it or
+// the cache was purged since generation was read.
+func (rev *Reverser) storeResponse(generation uint64, resp *cache.CachableResponse, r *http.Request, rc *config.Route, routeCache *cache.HttpCache) bool {
+	contextAllowsCaching := cache.IsRequestCachable(r.Method) && !cache.ParseRequestCacheControl(r).NoStore &&
+		((withoutAuthorizationHeader(r) && resp.IsCachable()) || resp.IsCachableConsideringAuth())
+	if !contextAllowsCaching {
+		return false
+	}
+
+	cacheDuration := cacheDurationWithFallback(resp.Header(), time.Duration(rc.CacheConfig.TTL)*time.Second)
+	return routeCache.SetAt(generation, r, resp, time.Now().Add(cacheDuration))
+}
+
 func (rev *Reverser) getLbforRoute(route string) (balancer.Balancer, bool) {
 	lb, exists := rev.lbs[route]
 	return lb, exists
@@ -152,42 +525,162 @@ func (rev *Reverser) getCacheForRoute(route string) (*cache.HttpCache, bool) {
 }
 
 func (rev *Reverser) Start() error {
-	rev.server = &http.Server{
-		Addr:    rev.config.Listen,
-		Handler: http.HandlerFunc(rev.handleRequest),
+	rev.servers = make([]*http.Server, 0, len(rev.config.Listeners)+2)
+	for _, l := range rev.config.Listeners {
+		rev.servers = append(rev.servers, rev.newServer(l))
+	}
+
+	var admin *http.Server
+	if rev.config.Purge.Address != "" {
+		admin = &http.Server{
+			Addr:    rev.config.Purge.Address,
+			Handler: rev.adminHandler(),
+		}
+		rev.servers = append(rev.servers, admin)
 	}
 
-	log.Printf("Reverse proxy listening on %s", rev.config.Listen)
-	return rev.server.ListenAndServe()
+	var peer *http.Server
+	if rev.config.Peering.Address != "" {
+		peer = &http.Server{
+			Addr:    rev.config.Peering.Address,
+			Handler: rev.peerHandler(),
+		}
+		rev.servers = append(rev.servers, peer)
+	}
+
+	errs := make(chan error, len(rev.servers))
+	for i, l := range rev.config.Listeners {
+		go func(server *http.Server, l config.Listener) {
+			log.Printf("Reverse proxy listening on %s (%s)", l.Address, l.Protocol)
+			errs <- listenAndServe(server, l)
+		}(rev.servers[i], l)
+	}
+
+	if admin != nil {
+		go func() {
+			log.Printf("Purge API listening on %s", admin.Addr)
+			errs <- admin.ListenAndServe()
+		}()
+	}
+
+	if peer != nil {
+		go func() {
+			log.Printf("Peering listening on %s", peer.Addr)
+			errs <- peer.ListenAndServe()
+		}()
+	}
+
+	return <-errs
+}
+
+func (rev *Reverser) newServer(l config.Listener) *http.Server {
+	server := &http.Server{
+		Addr:    l.Address,
+		Handler: rev.listenerHandler(l),
+	}
+
+	if l.Protocol == config.ListenerProtocolH2C {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return server
+}
+
+func listenAndServe(server *http.Server, l config.Listener) error {
+	if l.Protocol == config.ListenerProtocolHTTPS {
+		return server.ListenAndServeTLS(l.TLS.CertFile, l.TLS.KeyFile)
+	}
+
+	return server.ListenAndServe()
 }
 
 const gracefulWait = 15 * time.Second
 
 func (rev *Reverser) Stop() error {
-	if rev.server == nil {
+	if len(rev.servers) == 0 {
 		return nil
 	}
 
-	for _, c := range rev.caches {
-		c.Cleanup()
+	for _, checker := range rev.checkers {
+		checker.Stop()
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), gracefulWait)
 	defer cancel()
 
 	log.Println("Shutting down reverser...")
-	return rev.server.Shutdown(ctx)
+
+	var errs []error
+	for _, server := range rev.servers {
+		errs = append(errs, server.Shutdown(ctx))
+	}
+
+	// Snapshots are taken once in flight requests are done filling the caches.
+	for route, c := range rev.caches {
+		errs = append(errs, saveSnapshot(route, c, rev.config.Routes[route].CacheConfig.Snapshot))
+		c.Cleanup()
+	}
+
+	return errors.Join(errs...)
+}
+
+func loadSnapshot(route string, c *cache.HttpCache, path string) {
+	if path == "" {
+		return
+	}
+
+	loaded, err := c.LoadSnapshot(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil && loaded == 0:
+		log.Printf("Ignoring cache snapshot of route %s: %v", route, err)
+	case err != nil:
+		log.Printf("Restored %d cache entries of route %s, they may come back after a crash: %v", loaded, route, err)
+	default:
+		log.Printf("Restored %d cache entries of route %s", loaded, route)
+	}
+}
+
+func saveSnapshot(route string, c *cache.HttpCache, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	saved, err := c.SaveSnapshot(path)
+	if err != nil {
+		return fmt.Errorf("saving cache snapshot of route %s: %w", route, err)
+	}
+
+	log.Printf("Saved %d cache entries of route %s", saved, route)
+
+	return nil
+}
+
+// upstreamOf returns how a route reaches its backend dest.
+func upstreamOf(rc *config.Route, dest string) forwarder.Upstream {
+	upstream := forwarder.Upstream{URL: dest, TLS: rc.TLS}
+	if b, found := rc.Backend(dest); found {
+		upstream.TLS, upstream.Protocol = rc.UpstreamTLS(b), b.Protocol
+	}
+
+	return upstream
 }
 
 func withoutAuthorizationHeader(r *http.Request) bool {
 	return r.Header.Get("Authorization") == ""
 }
 
-func cacheDurationWithFallback(resp *cache.CachableResponse, fallback time.Duration) time.Duration {
-	specified, value := resp.CacheTTL()
-	if specified {
-		return value
+// cacheDurationWithFallback returns for how long a response received now stays
+// fresh: its explicit or heuristic freshness lifetime minus its initial age.
+func cacheDurationWithFallback(h http.Header, fallback time.Duration) time.Duration {
+	lifetime := fallback
+	if specified, value := cache.HeaderTTL(h); specified {
+		lifetime = value
+	} else if heuristic, value := cache.HeuristicTTL(h); heuristic {
+		lifetime = value
 	}
 
-	return fallback
+	return lifetime - cache.InitialAge(h, time.Now())
 }
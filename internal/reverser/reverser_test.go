@@ -1,11 +1,19 @@
 package reverser
 
 import (
+	"bufio"
 	"context"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,8 +21,18 @@ import (
 	"github.com/papey/cmiyc/internal/forwarder"
 )
 
+// newConfig builds a test configuration, which is always valid.
+func newConfig(listen string, routes map[string]config.Route) config.Config {
+	cfg, err := config.NewConfig(listen, routes)
+	if err != nil {
+		panic(err)
+	}
+
+	return cfg
+}
+
 func makeConfig(listen, backendURL string) config.Config {
-	return config.NewConfig(listen, map[string]config.Route{
+	return newConfig(listen, map[string]config.Route{
 		"/api": {
 			LoadBalancerType: config.LBStrategySingle,
 			Backends: []config.Backend{
@@ -73,7 +91,7 @@ func TestHandleRequestCacheHit(t *testing.T) {
 	}))
 	defer backend.Close()
 
-	cfg := config.NewConfig(":0", map[string]config.Route{
+	cfg := newConfig(":0", map[string]config.Route{
 		"/api": {
 			LoadBalancerType: config.LBStrategySingle,
 			CacheConfig: config.CacheConfig{
@@ -125,6 +143,1164 @@ func TestHandleRequestCacheHit(t *testing.T) {
 	}
 }
 
+func makeCachedConfig(backendURL string) config.Config {
+	return newConfig(":0", map[string]config.Route{
+		"/api": {
+			LoadBalancerType: config.LBStrategySingle,
+			CacheConfig: config.CacheConfig{
+				Enabled:      true,
+				TTL:          60,
+				MaxSize:      1, // MiB
+				MaxEntrySize: 1, // MiB
+			},
+			Backends: []config.Backend{
+				{URL: backendURL},
+			},
+		},
+	})
+}
+
+func TestHandleRequestRevalidatesStaleEntries(t *testing.T) {
+	var fullResponses, notModified atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.Header().Set("X-Revalidated", "yes")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		fullResponses.Add(1)
+		_, _ = io.WriteString(w, "cached body")
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api", nil))
+
+		if w.Code != http.StatusOK || w.Body.String() != "cached body" {
+			t.Fatalf("request %d: unexpected response %d %q", i, w.Code, w.Body.String())
+		}
+		if i > 0 && (w.Header().Get("X-Cache") != "HIT" || w.Header().Get("X-Revalidated") != "yes") {
+			t.Errorf("request %d: expected a revalidated hit, got %v", i, w.Header())
+		}
+	}
+
+	if fullResponses.Load() != 1 || notModified.Load() != 2 {
+		t.Errorf("expected 1 full response and 2 revalidations, got %d and %d", fullResponses.Load(), notModified.Load())
+	}
+
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	w := httptest.NewRecorder()
+	rev.handleRequest(w, req)
+
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected conditional client request to get a 304, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleRequestRevalidationReplacesChangedEntries(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, version.Load())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		_, _ = io.WriteString(w, "body "+etag)
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api", nil))
+		return w
+	}
+
+	if w := get(); w.Body.String() != `body "v1"` {
+		t.Fatalf("unexpected first body %q", w.Body.String())
+	}
+
+	version.Store(2)
+
+	w := get()
+	if w.Body.String() != `body "v2"` || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected the changed body from the backend, got %q (%s)", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	w = get()
+	if w.Body.String() != `body "v2"` || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected the new body to be cached, got %q (%s)", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+}
+
+func TestHandleRequestStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		_, _ = fmt.Fprintf(w, "v%d", version.Add(1))
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api", nil))
+		return w
+	}
+
+	if w := get(); w.Body.String() != "v1" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("unexpected first response %q (%s)", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	if w := get(); w.Body.String() != "v1" || w.Header().Get("X-Cache") != "STALE" {
+		t.Fatalf("expected the stale body to be served right away, got %q (%s)", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w := get()
+		if w.Body.String() != "v1" {
+			if w.Header().Get("X-Cache") != "STALE" {
+				t.Errorf("expected the refreshed entry to be served from cache, got %s", w.Header().Get("X-Cache"))
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("stale entry was never refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleRequestStaleIfError(t *testing.T) {
+	var failing atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=0")
+		_, _ = io.WriteString(w, "last known good")
+	}))
+	defer backend.Close()
+
+	cfg := makeCachedConfig(backend.URL)
+	route := cfg.Routes["/api"]
+	route.CacheConfig.StaleIfError = 60
+	cfg.Routes["/api"] = route
+	rev := NewReverser(cfg)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api", nil))
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusOK {
+		t.Fatalf("unexpected first response %d", w.Code)
+	}
+
+	failing.Store(true)
+	if w := get(); w.Code != http.StatusOK || w.Body.String() != "last known good" || w.Header().Get("X-Cache") != "STALE" {
+		t.Errorf("expected stale response on backend error, got %d %q (%s)", w.Code, w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	backend.Close()
+	if w := get(); w.Code != http.StatusOK || w.Body.String() != "last known good" {
+		t.Errorf("expected stale response with unreachable backend, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func coalescedRequests(t *testing.T, rev *Reverser, received <-chan struct{}, release chan<- struct{}, followers int) []*httptest.ResponseRecorder {
+	t.Helper()
+
+	recorders := make([]*httptest.ResponseRecorder, followers+1)
+	var wg sync.WaitGroup
+	serve := func(i int) {
+		defer wg.Done()
+		recorders[i] = httptest.NewRecorder()
+		rev.handleRequest(recorders[i], httptest.NewRequest("GET", "/api", nil))
+	}
+
+	wg.Add(1)
+	go serve(0)
+	<-received
+
+	for i := 1; i <= followers; i++ {
+		wg.Add(1)
+		go serve(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	return recorders
+}
+
+func TestHandleRequestCoalescesConcurrentMisses(t *testing.T) {
+	var hits atomic.Int32
+	received := make(chan struct{}, 16)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		received <- struct{}{}
+		<-release
+		_, _ = io.WriteString(w, "popular")
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	for i, w := range coalescedRequests(t, rev, received, release, 10) {
+		if w.Code != http.StatusOK || w.Body.String() != "popular" {
+			t.Errorf("request %d: unexpected response %d %q", i, w.Code, w.Body.String())
+		}
+	}
+
+	if hits.Load() != 1 {
+		t.Errorf("expected a single upstream fetch, got %d", hits.Load())
+	}
+}
+
+func TestHandleRequestCoalescingFallsBackOnUncachableResponses(t *testing.T) {
+	var hits atomic.Int32
+	received := make(chan struct{}, 16)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		received <- struct{}{}
+		<-release
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = io.WriteString(w, "personal")
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	for i, w := range coalescedRequests(t, rev, received, release, 5) {
+		if w.Code != http.StatusOK || w.Body.String() != "personal" || w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("request %d: unexpected response %d %q (%s)", i, w.Code, w.Body.String(), w.Header().Get("X-Cache"))
+		}
+	}
+
+	if hits.Load() != 6 {
+		t.Errorf("expected every request to reach the backend, got %d", hits.Load())
+	}
+}
+
+func TestHandleRequestCacheControlRequestDirectives(t *testing.T) {
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = io.WriteString(w, "resource")
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, req)
+		return w
+	}
+
+	if w := get(map[string]string{"Cache-Control": "only-if-cached"}); w.Code != http.StatusGatewayTimeout || hits.Load() != 0 {
+		t.Fatalf("expected only-if-cached miss to answer 504 without reaching the backend, got %d (%d hits)", w.Code, hits.Load())
+	}
+
+	get(map[string]string{"Cache-Control": "no-store"})
+	if w := get(map[string]string{"Cache-Control": "only-if-cached"}); w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected no-store request not to be stored, got %d", w.Code)
+	}
+
+	get(map[string]string{"Authorization": "Bearer token"})
+	if w := get(map[string]string{"Cache-Control": "only-if-cached"}); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected public authorized response to be stored, got %d (%s)", w.Code, w.Header().Get("X-Cache"))
+	}
+
+	before := hits.Load()
+	if w := get(map[string]string{"Cache-Control": "no-cache"}); w.Header().Get("X-Cache") != "MISS" || hits.Load() != before+1 {
+		t.Errorf("expected no-cache request to go to the backend, got %s", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestHandleRequestHeuristicFreshnessAndAge(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", time.Now().Add(-10*time.Hour).UTC().Format(http.TimeFormat))
+		w.Header().Set("Age", "20")
+		_, _ = io.WriteString(w, "static")
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api", nil))
+		return w
+	}
+
+	get()
+
+	entry, found := rev.caches["/api"].Lookup(httptest.NewRequest("GET", "/api", nil))
+	if !found {
+		t.Fatal("expected response to be cached")
+	}
+
+	if lifetime := time.Until(entry.ExpiresAt); lifetime < 55*time.Minute || lifetime > time.Hour {
+		t.Errorf("expected heuristic freshness of about an hour minus the upstream age, got %v", lifetime)
+	}
+
+	w := get()
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("expected a hit, got %s", w.Header().Get("X-Cache"))
+	}
+	if age := w.Header().Values("Age"); len(age) != 1 || (age[0] != "20" && age[0] != "21") {
+		t.Errorf("expected Age to account for the upstream age, got %v", age)
+	}
+}
+
+func TestHandleRequestUnsafeMethodsInvalidate(t *testing.T) {
+	var version atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = fmt.Fprintf(w, "%s v%d", r.URL.Path, version.Load())
+		case http.MethodPost:
+			version.Add(1)
+			w.Header().Set("Location", "/api/items/2")
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			version.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	do("GET", "/api/items/1")
+	do("GET", "/api/items/2")
+
+	do("PUT", "/api/items/1")
+	if w := do("GET", "/api/items/1"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "/api/items/1 v1" {
+		t.Errorf("expected PUT to invalidate its target, got %q (%s)", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	do("POST", "/api/items")
+	if w := do("GET", "/api/items/2"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "/api/items/2 v2" {
+		t.Errorf("expected POST to invalidate its Location, got %q (%s)", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+
+	do("DELETE", "/api/items/1")
+	if w := do("GET", "/api/items/1"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected failed DELETE not to invalidate, got %s", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestPurgeAPI(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Surrogate-Key", "items "+strings.TrimPrefix(r.URL.Path, "/api/"))
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	cfg := makeCachedConfig(backend.URL)
+	cfg.Purge = config.PurgeConfig{Method: true, Allow: []string{"192.0.2.0/24"}}
+	rev := NewReverser(cfg)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", path, nil))
+		return w.Header().Get("X-Cache")
+	}
+
+	purge := func(handler http.Handler, method, target, remoteAddr string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/api/a", "/api/b", "/api/c"} {
+		get(path)
+	}
+
+	main := http.HandlerFunc(rev.handleRequest)
+	if w := purge(main, MethodPurge, "/api/a", "203.0.113.7:1234", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("expected PURGE outside the ACL to be forbidden, got %d", w.Code)
+	}
+
+	if w := purge(main, MethodPurge, "/api/a", "192.0.2.10:1234", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"purged":1`) {
+		t.Fatalf("expected PURGE to purge its URL, got %d %s", w.Code, w.Body.String())
+	}
+	if get("/api/a") != "MISS" || get("/api/b") != "HIT" {
+		t.Error("expected only the purged URL to be dropped")
+	}
+
+	if w := purge(main, MethodPurge, "/api/", "192.0.2.10:1234", map[string]string{"Surrogate-Key": "b"}); !strings.Contains(w.Body.String(), `"purged":1`) {
+		t.Fatalf("expected PURGE with Surrogate-Key to purge the tag, got %s", w.Body.String())
+	}
+	if get("/api/b") != "MISS" {
+		t.Error("expected tagged entry to be purged")
+	}
+
+	admin := rev.adminHandler()
+	if w := purge(admin, "POST", "/purge?tag=items&route=/api", "192.0.2.10:1234", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"purged":3`) {
+		t.Fatalf("expected admin purge by tag, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := purge(admin, "POST", "/purge", "192.0.2.10:1234", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected empty selector to be rejected, got %d", w.Code)
+	}
+
+	if w := purge(admin, "POST", "/purge?prefix=/api&route=/unknown", "192.0.2.10:1234", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected unknown route to be rejected, got %d", w.Code)
+	}
+
+	if w := purge(admin, "POST", "/purge?prefix=/", "127.0.0.1:1234", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected admin API to enforce the ACL, got %d", w.Code)
+	}
+}
+
+// unreachableAddress returns the address of a closed listener.
+func unreachableAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+
+	return l.Addr().String()
+}
+
+func TestPurgeAPIReportsSharedStoreFailures(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	local := makeCachedConfig(backend.URL).Routes["/api"]
+	shared := local
+	shared.CacheConfig.Redis.Address = unreachableAddress(t)
+	rev := NewReverser(newConfig(":0", map[string]config.Route{"/api": local, "/shared": shared}))
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", path, nil))
+		return w.Header().Get("X-Cache")
+	}
+	purge := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rev.handleAdminPurge(w, httptest.NewRequest("POST", target, nil))
+		return w
+	}
+
+	for _, path := range []string{"/api/a", "/shared/a"} {
+		get(path)
+	}
+
+	w := purge("/purge?prefix=/shared&route=/shared")
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), `"purged":1`) {
+		t.Errorf("expected a shared store failure to be a 502 with the local count, got %d %s", w.Code, w.Body.String())
+	}
+	if got := get("/shared/a"); got != "MISS" {
+		t.Errorf("expected the entry to be purged locally, got %s", got)
+	}
+
+	get("/shared/a")
+	w = purge("/purge?glob=*/a")
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), `"purged":2`) {
+		t.Errorf("expected every route to be purged despite the failure, got %d %s", w.Code, w.Body.String())
+	}
+	if get("/api/a") != "MISS" || get("/shared/a") != "MISS" {
+		t.Error("expected the entries of both routes to be purged")
+	}
+
+	if w := purge("/purge"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an empty selector to stay a 400, got %d", w.Code)
+	}
+}
+
+func TestHandleRequestServesLargeResponsesFromDisk(t *testing.T) {
+	var requests atomic.Int32
+	body := strings.Repeat("x", 2<<20) // larger than max_entry_size
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	cfg := makeCachedConfig(backend.URL)
+	route := cfg.Routes["/api"]
+	route.CacheConfig.Disk = config.DiskCacheConfig{Path: t.TempDir(), MaxSize: 4}
+	cfg.Routes["/api"] = route
+	rev := NewReverser(cfg)
+	defer rev.caches["/api"].Cleanup()
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api/large", nil))
+		if w.Code != http.StatusOK || w.Body.Len() != len(body) {
+			t.Fatalf("request %d: unexpected response %d with %d bytes", i, w.Code, w.Body.Len())
+		}
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("expected the second request to be served from disk, got %d backend requests", requests.Load())
+	}
+	if stats := rev.caches["/api"].Stats(); stats.DiskEntries != 1 {
+		t.Errorf("expected the response on the disk tier, got %+v", stats)
+	}
+}
+
+func TestCacheSnapshotSurvivesRestart(t *testing.T) {
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = io.WriteString(w, "cached before restart")
+	}))
+	defer backend.Close()
+
+	cfg := makeCachedConfig(backend.URL)
+	route := cfg.Routes["/api"]
+	route.CacheConfig.Snapshot = filepath.Join(t.TempDir(), "api.snapshot")
+	cfg.Routes["/api"] = route
+
+	get := func(rev *Reverser) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api/resource", nil))
+		return w
+	}
+
+	first := NewReverser(cfg)
+	get(first)
+	if err := saveSnapshot("/api", first.caches["/api"], route.CacheConfig.Snapshot); err != nil {
+		t.Fatalf("saveSnapshot returned error: %v", err)
+	}
+
+	restarted := NewReverser(cfg)
+	w := get(restarted)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "cached before restart" {
+		t.Errorf("expected the restarted reverser to serve the snapshot, got %s %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected a single backend request, got %d", requests.Load())
+	}
+}
+
+func TestPurgedEntriesStayPurgedAfterACrash(t *testing.T) {
+	var version atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "v%d", version.Add(1))
+	}))
+	defer backend.Close()
+
+	cfg := makeCachedConfig(backend.URL)
+	route := cfg.Routes["/api"]
+	route.CacheConfig.Snapshot = filepath.Join(t.TempDir(), "api.snapshot")
+	cfg.Routes["/api"] = route
+
+	get := func(rev *Reverser) string {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", "/api/resource", nil))
+		return w.Body.String()
+	}
+
+	first := NewReverser(cfg)
+	get(first)
+	if err := saveSnapshot("/api", first.caches["/api"], route.CacheConfig.Snapshot); err != nil {
+		t.Fatalf("saveSnapshot returned error: %v", err)
+	}
+
+	restarted := NewReverser(cfg)
+	w := httptest.NewRecorder()
+	restarted.handleAdminPurge(w, httptest.NewRequest("POST", "/purge?prefix=/api/resource", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("purge failed: %d %s", w.Code, w.Body.String())
+	}
+
+	// The restarted instance crashes, no snapshot is saved.
+	crashed := NewReverser(cfg)
+	if body := get(crashed); body != "v2" {
+		t.Errorf("expected the purged entry not to come back, got %q", body)
+	}
+}
+
+func TestHandleRequestServesCompressedEntries(t *testing.T) {
+	body := strings.Repeat("compressible ", 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	}))
+	defer backend.Close()
+
+	cfg := makeCachedConfig(backend.URL)
+	route := cfg.Routes["/api"]
+	route.CacheConfig.Compression = "gzip"
+	cfg.Routes["/api"] = route
+	rev := NewReverser(cfg)
+
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/resource", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, req)
+		return w
+	}
+
+	get("")
+
+	encoded := get("gzip")
+	if encoded.Header().Get("Content-Encoding") != "gzip" || encoded.Body.Len() >= len(body) {
+		t.Errorf("expected a gzip encoded hit, got %q in %d bytes", encoded.Header().Get("Content-Encoding"), encoded.Body.Len())
+	}
+
+	identity := get("")
+	if identity.Header().Get("X-Cache") != "HIT" || identity.Body.String() != body {
+		t.Errorf("expected a decompressed hit, got %s", identity.Header().Get("X-Cache"))
+	}
+}
+
+func TestHandleRequestServesRangesFromFullResponses(t *testing.T) {
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "media", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	get := func(rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/media", nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, req)
+		return w
+	}
+
+	if w := get("bytes=0-3"); w.Code != http.StatusPartialContent || w.Body.String() != "0123" {
+		t.Fatalf("expected a slice of the fetched response, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get(""); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "0123456789" {
+		t.Fatalf("expected the range miss to store the whole response, got %s %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	w := get("bytes=4-6")
+	if w.Code != http.StatusPartialContent || w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "456" {
+		t.Errorf("expected a slice of the cached response, got %d %s %q", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected a single backend request, got %d", requests.Load())
+	}
+}
+
+func TestHandleRequestForwardsRangesOfUncachableResponses(t *testing.T) {
+	var ranges []string
+	var mu sync.Mutex
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, "media", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer backend.Close()
+
+	rev := NewReverser(makeCachedConfig(backend.URL))
+
+	req := httptest.NewRequest("GET", "/api/media", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	w := httptest.NewRecorder()
+	rev.handleRequest(w, req)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("expected the backend partial response, got %d %q", w.Code, w.Body.String())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=2-4" {
+		t.Errorf("expected a whole fetch, then the range request to be forwarded, got %q", ranges)
+	}
+}
+
+func TestHandleRequestUpstreamTLSWithCustomCA(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "over tls")
+	}))
+	defer backend.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+
+	cfg := newConfig(":0", map[string]config.Route{
+		"/api": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			Backends: []config.Backend{
+				{URL: backend.URL, TLS: config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"}},
+			},
+		},
+	})
+	rev := NewReverser(cfg)
+
+	req := httptest.NewRequest("GET", "/api", nil)
+	w := httptest.NewRecorder()
+	rev.handleRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "over tls" {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+
+	untrusted := NewReverser(makeConfig(":0", backend.URL))
+	w = httptest.NewRecorder()
+	untrusted.handleRequest(w, httptest.NewRequest("GET", "/api", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 without CA bundle, got %d", w.Code)
+	}
+}
+
+func TestRoutesSharingABackendKeepTheirOwnTLSSettings(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "over tls")
+	}))
+	defer backend.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+
+	rev := NewReverser(newConfig(":0", map[string]config.Route{
+		"/trusted": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			TLS:      config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"},
+			Backends: []config.Backend{{URL: backend.URL}},
+		},
+		"/untrusted": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			Backends: []config.Backend{{URL: backend.URL}},
+		},
+	}))
+
+	for path, expected := range map[string]int{"/trusted": http.StatusOK, "/untrusted": http.StatusBadGateway} {
+		w := httptest.NewRecorder()
+		rev.handleRequest(w, httptest.NewRequest("GET", path, nil))
+
+		if w.Code != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, w.Code)
+		}
+	}
+}
+
+func TestListenersServeTheirOwnRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "path="+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	route := config.Route{
+		LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+		Backends: []config.Backend{{URL: backend.URL}},
+	}
+	cfg, err := config.NewConfigWithListeners([]config.Listener{
+		{Address: ":0", Routes: []string{"/api"}},
+		{Address: ":0", Protocol: config.ListenerProtocolH2C},
+	}, map[string]config.Route{
+		"/api":      route,
+		"/internal": route,
+	})
+	if err != nil {
+		t.Fatalf("unexpected configuration error: %v", err)
+	}
+
+	rev := NewReverser(cfg)
+	public := rev.listenerHandler(cfg.Listeners[0])
+	internal := rev.listenerHandler(cfg.Listeners[1])
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		path     string
+		expected int
+	}{
+		{"public serves its route", public, "/api/users", http.StatusOK},
+		{"public hides other routes", public, "/internal/metrics", http.StatusNotFound},
+		{"internal serves every route", internal, "/internal/metrics", http.StatusOK},
+		{"internal shares backends", internal, "/api/users", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestListenersInvalidateInTheirOwnRoutes(t *testing.T) {
+	var fetches atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fetches.Add(1)
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "item")
+	}))
+	defer backend.Close()
+
+	route := config.Route{
+		LBConfig:    config.LBConfig{Type: config.LBStrategySingle},
+		CacheConfig: config.CacheConfig{Enabled: true, MaxSize: 1, MaxEntrySize: 1, TTL: 60},
+		Backends:    []config.Backend{{URL: backend.URL}},
+	}
+	cfg, err := config.NewConfigWithListeners([]config.Listener{
+		{Address: ":0", Routes: []string{"/api"}},
+	}, map[string]config.Route{
+		"/api":    route,
+		"/api/v2": route,
+	})
+	if err != nil {
+		t.Fatalf("unexpected configuration error: %v", err)
+	}
+
+	rev := NewReverser(cfg)
+	public := rev.listenerHandler(cfg.Listeners[0])
+
+	for _, method := range []string{"GET", "POST", "GET"} {
+		public.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/api/v2/item", nil))
+	}
+
+	// The listener serves /api/v2/item from /api, the POST must invalidate it there.
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("expected the entry to be fetched again after the POST, got %d fetches", got)
+	}
+}
+
+func TestListenersRejectUnknownRoutes(t *testing.T) {
+	_, err := config.NewConfigWithListeners([]config.Listener{
+		{Address: ":0", Routes: []string{"/missing"}},
+	}, map[string]config.Route{})
+	if err == nil {
+		t.Fatal("expected an error for a listener serving an unknown route")
+	}
+}
+
+func TestHandleRequestUpgrade(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = buf.Flush()
+
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("echo: " + line))
+	}))
+	defer backend.Close()
+
+	cfg := newConfig(":0", map[string]config.Route{
+		"/ws": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			Backends: []config.Backend{{URL: backend.URL}},
+		},
+	})
+	rev := NewReverser(cfg)
+	ts := httptest.NewServer(http.HandlerFunc(rev.handleRequest))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Upgrade") != "echo" {
+		t.Errorf("expected Upgrade=echo, got %s", resp.Header.Get("Upgrade"))
+	}
+
+	if active := rev.lbs["/ws"].ActiveConnections(backend.URL); active != 1 {
+		t.Errorf("expected 1 active connection, got %d", active)
+	}
+
+	_, _ = io.WriteString(conn, "ping\n")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+	if line != "echo: ping\n" {
+		t.Errorf("unexpected echo: %q", line)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for rev.lbs["/ws"].ActiveConnections(backend.URL) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if active := rev.lbs["/ws"].ActiveConnections(backend.URL); active != 0 {
+		t.Errorf("expected connection to be released, got %d", active)
+	}
+}
+
+func TestHandleRequestStreamsEventStream(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		<-release
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+
+	cfg := newConfig(":0", map[string]config.Route{
+		"/events": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			CacheConfig: config.CacheConfig{
+				Enabled:      true,
+				TTL:          60,
+				MaxSize:      1, // MiB
+				MaxEntrySize: 1, // MiB
+			},
+			Backends: []config.Backend{{URL: backend.URL}},
+		},
+	})
+	rev := NewReverser(cfg)
+	ts := httptest.NewServer(http.HandlerFunc(rev.handleRequest))
+	defer ts.Close()
+	defer close(release)
+
+	resp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		received <- line
+	}()
+
+	select {
+	case line := <-received:
+		if line != "data: first\n" {
+			t.Errorf("unexpected event: %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the first event to be flushed before the stream ends")
+	}
+
+	if entries := rev.caches["/events"].Stats().Entries; entries != 0 {
+		t.Errorf("expected event streams to not be cached, got %d entries", entries)
+	}
+}
+
+func newH2CTestServer(handler http.Handler) *httptest.Server {
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+
+	return ts
+}
+
+func newH2CTestClient() *http.Client {
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Client{Transport: transport}
+}
+
+func TestHandleRequestGRPCTrailers(t *testing.T) {
+	backend := newH2CTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("TE") != "trailers" {
+			http.Error(w, "expected h2c with TE: trailers", http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", r.Trailer.Get("X-Request-Trailer"))
+	}))
+	defer backend.Close()
+
+	cfg := newConfig(":0", map[string]config.Route{
+		"/": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			Backends: []config.Backend{
+				{URL: backend.URL, Protocol: config.BackendProtocolH2C},
+			},
+		},
+	})
+	rev := NewReverser(cfg)
+	proxy := newH2CTestServer(http.HandlerFunc(rev.handleRequest))
+	defer proxy.Close()
+
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest("POST", proxy.URL+"/helloworld.Greeter/SayHello", pr)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Trailer = http.Header{"X-Request-Trailer": nil}
+	go func() {
+		_, _ = io.WriteString(pw, "\x00\x00\x00\x00\x02hi")
+		req.Trailer.Set("X-Request-Trailer", "from-client")
+		_ = pw.Close()
+	}()
+
+	resp, err := newH2CTestClient().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	if string(body) != "\x00\x00\x00\x00\x02hi" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("expected grpc-status trailer 0, got %q", got)
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); got != "from-client" {
+		t.Errorf("expected request trailer to reach the backend, got %q", got)
+	}
+}
+
+func TestHandleRequestGRPCBackendUnreachable(t *testing.T) {
+	cfg := newConfig(":0", map[string]config.Route{
+		"/": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			Backends: []config.Backend{{URL: "http://127.0.0.1:1", Protocol: config.BackendProtocolH2C}},
+		},
+	})
+	rev := NewReverser(cfg)
+
+	req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/grpc")
+	w := httptest.NewRecorder()
+	rev.handleRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected gRPC errors to use HTTP 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Grpc-Status"); got != "14" {
+		t.Errorf("expected grpc-status 14 (UNAVAILABLE), got %q", got)
+	}
+	if w.Header().Get("Grpc-Message") == "" {
+		t.Error("expected a grpc-message describing the failure")
+	}
+}
+
+func TestHandleRequestTimesOutStalledBodies(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	cfg := newConfig(":0", map[string]config.Route{
+		"/api": {
+			LBConfig: config.LBConfig{Type: config.LBStrategySingle},
+			Backends: []config.Backend{{URL: backend.URL}},
+			Timeout:  1,
+		},
+	})
+	rev := NewReverser(cfg)
+
+	done := make(chan struct{})
+	go func() {
+		rev.handleRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/stalled", nil))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the route timeout to end a stalled response body")
+	}
+}
+
 func TestHandleRequestRouteNotFound(t *testing.T) {
 	cfg := makeConfig(":0", "http://localhost")
 	rev := NewReverser(cfg)
@@ -150,7 +1326,7 @@ func TestStartAndStop(t *testing.T) {
 	}()
 	time.Sleep(50 * time.Millisecond)
 
-	if rev.server == nil {
+	if len(rev.servers) == 0 {
 		t.Fatal("expected server to be initialized")
 	}
 